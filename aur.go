@@ -19,144 +19,572 @@ package main
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
+// RPCVersion is the version of the AUR's RPC interface that pacgo
+// speaks.
+const RPCVersion = 5
+
 // RPCURL returns the url for the AUR's RPC system using the given
-// type and arg. url.QueryEscape() is run on both t and arg.
-func RPCURL(t, arg string) string {
-	t = url.QueryEscape(t)
-	arg = url.QueryEscape(arg)
+// type and args. For info requests each arg is sent as a separate
+// arg[] parameter. Otherwise, only the first arg is used.
+func RPCURL(t string, args ...string) string {
+	q := url.Values{}
+	q.Set("v", fmt.Sprint(RPCVersion))
+	q.Set("type", t)
+
+	switch t {
+	case "info", "multiinfo":
+		for _, arg := range args {
+			q.Add("arg[]", arg)
+		}
+	default:
+		if len(args) > 0 {
+			q.Set("arg", args[0])
+		}
+	}
 
-	return "https://aur.archlinux.org/rpc.php?type=" + t + "&arg=" + arg
+	return AURURL + "/rpc/?" + q.Encode()
 }
 
 // PKGURL returns the url for the given package with the given
 // sub-path.
 func PKGURL(pkg, path string) string {
-	return "https://aur.archlinux.org/packages/" + pkg[:2] + "/" + pkg + "/" + path
+	return AURURL + "/packages/" + pkg[:2] + "/" + pkg + "/" + path
+}
+
+// GitURL returns the url of the git repository for the given
+// package base.
+func GitURL(base string) string {
+	return AURURL + "/" + base + ".git"
+}
+
+// AURPackage represents the information that the AUR's RPC system
+// returns about a single package.
+type AURPackage struct {
+	ID             int
+	Name           string
+	PackageBaseID  int
+	PackageBase    string
+	Version        string
+	Description    string
+	URL            string
+	NumVotes       int
+	Popularity     float64
+	OutOfDate      int64 // Zero if the package isn't flagged.
+	Maintainer     string
+	FirstSubmitted int64
+	LastModified   int64
+	URLPath        string
+
+	Depends      []string
+	MakeDepends  []string
+	CheckDepends []string
+	OptDepends   []string
+	Conflicts    []string
+	Provides     []string
+	Replaces     []string
+	Groups       []string
+	License      []string
+	Keywords     []string
+}
+
+// MaintainerString returns the name of the package's maintainer, or
+// "None" if the package is orphaned.
+func (p *AURPackage) MaintainerString() string {
+	if p.Maintainer == "" {
+		return "None"
+	}
+
+	return p.Maintainer
+}
+
+// OutOfDateString returns the date that the package was flagged out
+// of date, or "No" if it isn't flagged.
+func (p *AURPackage) OutOfDateString() string {
+	if p.OutOfDate == 0 {
+		return "No"
+	}
+
+	return time.Unix(p.OutOfDate, 0).Format("Mon 02 Jan 2006")
 }
 
 // RPCResult represents a response from the AUR's RPC system.
 type RPCResult struct {
+	Version     int
 	Type        string
 	ResultCount int
-	Results     interface{}
+	Results     []AURPackage
+	Error       string
 }
 
-// AURInfo retrieves the information about a specific package from
-// the AUR. It returns the result and an error, if any.
-func AURInfo(name string) (info RPCResult, err error) {
-	rsp, err := http.Get(RPCURL("info", name))
+// rpc performs an RPC call of the given type with the given args. It
+// returns the decoded result and nil, or nil and an error, if any.
+func rpc(t string, args ...string) (*RPCResult, error) {
+	return rpcGet(RPCURL(t, args...), t, strings.Join(args, " "))
+}
+
+// rpcGet performs the RPC call at the given url. Searches use a cached
+// response if possible. Info is cached for each package by
+// AURInfoMulti() instead, so it isn't cached here as well. t and arg
+// are only used for errors. It returns the decoded result and nil, or
+// nil and an error, if any.
+func rpcGet(u, t, arg string) (*RPCResult, error) {
+	get := CachedGet
+	if t == "info" {
+		get = HTTPGetBody
+	}
+
+	body, err := get(u)
 	if err != nil {
-		return
+		if err == ErrOffline {
+			return nil, fmt.Errorf("AUR RPC request for %v %v is not cached.", t, arg)
+		}
+		return nil, fmt.Errorf("AUR RPC request failed: %v", err)
 	}
-	defer rsp.Body.Close()
 
-	d := json.NewDecoder(rsp.Body)
-	err = d.Decode(&info)
+	var info RPCResult
+	err = json.Unmarshal(body, &info)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	if info.Type == "error" {
-		err = &RPCError{
-			Type: "info",
-			Arg:  name,
-			Err:  info.Results.(string),
+		return nil, &RPCError{
+			Type: t,
+			Arg:  arg,
+			Err:  info.Error,
+		}
+	}
+
+	// The names are used in paths, so results with names that could
+	// point outside of the cache are dropped.
+	results := info.Results[:0]
+	for _, r := range info.Results {
+		err := CheckPkgName(r.Name)
+		if (err == nil) && (r.PackageBase != "") {
+			err = CheckPkgName(r.PackageBase)
+		}
+		if err != nil {
+			Cprintf("[c6]warning:[ce] Ignoring AUR result: %v\n", err)
+			continue
+		}
+
+		results = append(results, r)
+	}
+	info.Results = results
+
+	return &info, nil
+}
+
+// AURInfo retrieves the information about a specific package from
+// the AUR. It returns the result and nil, or nil and an error, if
+// any.
+func AURInfo(name string) (*AURPackage, error) {
+	infos, err := AURInfoMulti([]string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	if info, ok := infos[name]; ok {
+		return info, nil
+	}
+
+	return nil, &RPCError{
+		Type: "info",
+		Arg:  name,
+		Err:  "No results found",
+	}
+}
+
+// MaxRPCURLLen is the maximum length of the URL used for a single
+// batched info request. AURInfoMulti() splits larger requests into
+// several smaller ones.
+const MaxRPCURLLen = 4000
+
+// AURInfoMulti retrieves the information about several packages from
+// the AUR, using as few requests as possible. It returns a map of
+// package names to their info and nil, or nil and an error, if any.
+// Packages that aren't in the AUR are simply left out of the map.
+// Info that was cached less than CacheTTL ago, including the fact that
+// a package isn't in the AUR, is used without asking the AUR.
+func AURInfoMulti(names []string) (map[string]*AURPackage, error) {
+	infos := make(map[string]*AURPackage, len(names))
+
+	stale := make([]string, 0, len(names))
+	for _, name := range names {
+		if info, ok := cachedInfo(name); ok {
+			if info != nil {
+				infos[name] = info
+			}
+			continue
 		}
-		return
+		stale = append(stale, name)
 	}
+	names = stale
+	if Offline && (len(names) > 0) {
+		return nil, fmt.Errorf("Info for %v is not cached.", strings.Join(names, ", "))
+	}
+
+	base := len(RPCURL("info"))
+	for len(names) > 0 {
+		n := 0
+		size := base
+		for _, name := range names {
+			size += len("&arg%5B%5D=") + len(url.QueryEscape(name))
+			if (size > MaxRPCURLLen) && (n > 0) {
+				break
+			}
+			n++
+		}
 
-	if info.ResultCount == 0 {
-		err = &RPCError{
-			Type: "info",
-			Arg:  name,
-			Err:  "",
+		info, err := rpc("info", names[:n]...)
+		if err != nil {
+			return nil, err
 		}
-		return
+
+		for i := range info.Results {
+			infos[info.Results[i].Name] = &info.Results[i]
+			cacheInfo(info.Results[i].Name, &info.Results[i])
+		}
+		for _, name := range names[:n] {
+			if _, ok := infos[name]; !ok {
+				cacheInfo(name, nil)
+			}
+		}
+
+		names = names[n:]
 	}
 
-	return
+	return infos, nil
 }
 
 // AURSearch retrieves search results from the AUR using the given
 // search. It returns the results and an error, if any.
-func AURSearch(arg string) (info RPCResult, err error) {
-	rsp, err := http.Get(RPCURL("search", arg))
+func AURSearch(arg string) ([]AURPackage, error) {
+	info, err := rpc("search", arg)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer rsp.Body.Close()
 
-	d := json.NewDecoder(rsp.Body)
-	err = d.Decode(&info)
+	return info.Results, nil
+}
+
+// AURSearchBy is like AURSearch(), but it searches the given field,
+// such as "provides" or "depends", instead of names and descriptions.
+// It returns the results and an error, if any.
+func AURSearchBy(by, arg string) ([]AURPackage, error) {
+	u := RPCURL("search", arg) + "&by=" + url.QueryEscape(by)
+
+	info, err := rpcGet(u, "search", arg)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	if info.Type == "error" {
-		err = &RPCError{
-			Type: "search",
-			Arg:  arg,
-			Err:  info.Results.(string),
-		}
-		return
+	return info.Results, nil
+}
+
+// These are the kinds of *SourceTarError.
+const (
+	// The AUR doesn't have a source tarball for the package.
+	SourceNotFound = iota
+
+	// The server failed to respond properly.
+	SourceServerError
+
+	// The downloaded data wasn't a valid source tarball.
+	SourceCorrupt
+
+	// The source tarball was larger than MaxSourceSize.
+	SourceTooLarge
+)
+
+// SourceTarError is returned by ExtractSourceTar() when a source
+// tarball can't be downloaded or extracted.
+type SourceTarError struct {
+	Kind int    // One of the kinds above.
+	Pkg  string // The package whose source tarball was being fetched.
+	Err  error  // The underlying error, if there is one.
+}
+
+func (err *SourceTarError) Error() string {
+	switch err.Kind {
+	case SourceNotFound:
+		return fmt.Sprintf("%v: Source tarball not found.", err.Pkg)
+	case SourceServerError:
+		return fmt.Sprintf("%v: Server error: %v", err.Pkg, err.Err)
+	case SourceCorrupt:
+		return fmt.Sprintf("%v: Corrupt source tarball: %v", err.Pkg, err.Err)
+	case SourceTooLarge:
+		return fmt.Sprintf("%v: Source tarball is larger than %v bytes.", err.Pkg, MaxSourceSize)
+	}
+
+	return fmt.Sprintf("%v: %v", err.Pkg, err.Err)
+}
+
+// errSourceTooLarge is returned by sourceLimitReader once the limit
+// has been reached.
+var errSourceTooLarge = errors.New("Source tarball is too large.")
+
+// sourceLimitReader is like io.LimitedReader, but it returns an error
+// if there's more data than the limit, rather than io.EOF.
+type sourceLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *sourceLimitReader) Read(buf []byte) (int, error) {
+	if lr.n <= 0 {
+		return 0, errSourceTooLarge
+	}
+	if int64(len(buf)) > lr.n {
+		buf = buf[:lr.n]
 	}
 
-	return
+	n, err := lr.r.Read(buf)
+	lr.n -= int64(n)
+	return n, err
 }
 
-// GetInfo gets the given information from an RPCResult returned by
-// AURInfo().
-func (r RPCResult) GetInfo(k string) string {
-	return r.Results.(map[string]interface{})[k].(string)
+// sourceBody wraps the body of a source tarball's response, keeping
+// track of any error other than io.EOF that reading it returns, so
+// that network failures can be told apart from corrupt data once the
+// error has been passed through the decompressor.
+type sourceBody struct {
+	r   io.Reader
+	err error
 }
 
-// GetSearch gets the given information from the given search result
-// from an RPCResult returned by AURSearch().
-func (r RPCResult) GetSearch(i int, k string) string {
-	return r.Results.([]interface{})[i].(map[string]interface{})[k].(string)
+func (b *sourceBody) Read(buf []byte) (int, error) {
+	n, err := b.r.Read(buf)
+	if (err != nil) && (err != io.EOF) && (b.err == nil) {
+		b.err = err
+	}
+	return n, err
 }
 
-// GetSourceTar retrieves the source tar for the named package from
-// the AUR and returns it as a *tar.Reader. It returns the result and
-// nil, or nil and an error, if any.
-func GetSourceTar(name string) (*tar.Reader, error) {
-	rsp, err := http.Get(PKGURL(name, name+".tar.gz"))
+// sourceTypes are the content types that a source tarball may be
+// served with.
+var sourceTypes = map[string]bool{
+	"":                         true,
+	"application/gzip":         true,
+	"application/x-gzip":       true,
+	"application/x-tgz":        true,
+	"application/x-tar":        true,
+	"application/octet-stream": true,
+}
+
+// ExtractSourceTar downloads the source tarball for the named package
+// from the AUR and extracts it into dir as it's downloaded. Neither
+// the download nor its uncompressed contents may be larger than
+// MaxSourceSize. It returns an error, if any. Problems with the
+// download or the archive are returned as a *SourceTarError.
+func ExtractSourceTar(dir, name string) error {
+	rsp, err := HTTPGet(PKGURL(name, name+".tar.gz"))
 	if err != nil {
-		return nil, err
+		return &SourceTarError{SourceServerError, name, err}
 	}
 	defer rsp.Body.Close()
 
-	uz, err := gzip.NewReader(rsp.Body)
+	switch {
+	case rsp.StatusCode == http.StatusNotFound:
+		return &SourceTarError{SourceNotFound, name, nil}
+	case rsp.StatusCode != http.StatusOK:
+		return &SourceTarError{SourceServerError, name, errors.New(rsp.Status)}
+	}
+
+	ctype := strings.TrimSpace(strings.SplitN(rsp.Header.Get("Content-Type"), ";", 2)[0])
+	if !sourceTypes[ctype] {
+		return &SourceTarError{SourceCorrupt, name, fmt.Errorf("Unexpected content type: %v", ctype)}
+	}
+	if rsp.ContentLength > MaxSourceSize {
+		return &SourceTarError{SourceTooLarge, name, nil}
+	}
+
+	body := &sourceBody{r: rsp.Body}
+	uz, err := gzip.NewReader(&sourceLimitReader{body, MaxSourceSize})
 	if err != nil {
-		return nil, err
+		if body.err != nil {
+			return &SourceTarError{SourceServerError, name, body.err}
+		}
+		if err == errSourceTooLarge {
+			return &SourceTarError{SourceTooLarge, name, nil}
+		}
+		return &SourceTarError{SourceCorrupt, name, err}
+	}
+	defer uz.Close()
+
+	err = ExtractTar(dir, tar.NewReader(&sourceLimitReader{uz, MaxSourceSize}))
+	if body.err != nil {
+		return &SourceTarError{SourceServerError, name, body.err}
 	}
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, uz)
+	switch err {
+	case nil:
+		return nil
+	case errSourceTooLarge:
+		return &SourceTarError{SourceTooLarge, name, nil}
+	case tar.ErrHeader, gzip.ErrHeader, gzip.ErrChecksum, io.ErrUnexpectedEOF:
+		return &SourceTarError{SourceCorrupt, name, err}
+	}
+
+	return err
+}
+
+// AURSrcDir returns the directory in CacheDir that the build files
+// for the given package base are kept in and nil, or "" and an error
+// if base isn't a valid package base.
+func AURSrcDir(base string) (string, error) {
+	err := CheckPkgName(base)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	r := tar.NewReader(&buf)
+	return filepath.Join(CacheDir, "aur", base), nil
+}
+
+// fetches keeps track of the package bases that have already been
+// fetched during this run, so that packages from the same package
+// base don't fetch it more than once, or at the same time.
+var fetches = struct {
+	sync.Mutex
+	m map[string]*fetch
+}{m: make(map[string]*fetch)}
+
+type fetch struct {
+	once sync.Once
+	err  error
+}
+
+// FetchAUR fetches the build files for the given package base into
+// dir/base. If dir/base is already a git repository, it is
+// fast-forwarded. Otherwise, it is cloned. If git isn't available or
+// fails, the source tarball is downloaded and extracted instead. It
+// returns an error, if any.
+func FetchAUR(dir, base string) error {
+	key := filepath.Join(dir, base)
+
+	fetches.Lock()
+	f, ok := fetches.m[key]
+	if !ok {
+		f = new(fetch)
+		fetches.m[key] = f
+	}
+	fetches.Unlock()
+
+	f.once.Do(func() {
+		f.err = fetchAUR(dir, base)
+	})
+
+	return f.err
+}
+
+func fetchAUR(dir, base string) error {
+	if Offline {
+		return fmt.Errorf("Can't fetch %v while offline.", base)
+	}
+
+	err := fetchGit(dir, base)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*GitDivergedError); ok {
+		// Extracting the tarball would overwrite the local changes.
+		return err
+	}
+
+	if GitPath != "" {
+		Cprintf("[c6]warning:[ce] Failed to fetch %v with git (%v). Trying the source tarball...\n", base, err)
+	}
+
+	return ExtractSourceTar(dir, base)
+}
+
+// GitDivergedError is returned when the git repository of a package
+// base can't be fast-forwarded to the AUR's version, usually because
+// of local changes.
+type GitDivergedError struct {
+	Base string
+	Repo string
+}
+
+func (err *GitDivergedError) Error() string {
+	return fmt.Sprintf("%v can't be fast-forwarded to the AUR's version. Merge or discard the local changes in %v first.", err.Base, err.Repo)
+}
+
+// fetchGit clones or fast-forwards the git repository for the given
+// package base into dir/base. If dir/base exists but isn't a git
+// repository, such as when it was extracted from a source tarball, it
+// is moved to dir/base.old first. It returns an error, if any.
+func fetchGit(dir, base string) error {
+	if GitPath == "" {
+		return errors.New("Could not find git.")
+	}
+
+	repo := filepath.Join(dir, base)
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err == nil {
+		err = GitIn(repo, "fetch", "-q", "origin")
+		if err != nil {
+			return err
+		}
+
+		err = GitIn(repo, "merge", "-q", "--ff-only", "origin/HEAD")
+		if err != nil {
+			return &GitDivergedError{base, repo}
+		}
+	} else {
+		if _, err := os.Stat(repo); err == nil {
+			old := repo + ".old"
+			if _, err := os.Lstat(old); err == nil {
+				return fmt.Errorf("%v isn't a git repository, and %v is in the way of moving it.", repo, old)
+			}
+
+			err = os.Rename(repo, old)
+			if err != nil {
+				return err
+			}
+			Cprintf("[c6]warning:[ce] %v wasn't a git repository. It was moved to %v.\n", repo, old)
+		}
+
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+
+		err = GitIn(dir, "clone", "-q", GitURL(base), base)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The AUR happily serves empty repositories for packages that
+	// don't exist.
+	if _, err := os.Stat(filepath.Join(repo, "PKGBUILD")); err != nil {
+		return fmt.Errorf("%v has no PKGBUILD.", GitURL(base))
+	}
 
-	return r, nil
+	return nil
 }
 
 // RPCError represents an error returned by the AUR's RPC system.
 type RPCError struct {
 	Type string // The type that was used in the RPC call.
 	Arg  string // The arg that was used in the RPC call.
-	Err  string // The text of the error returned by rpc.php.
+	Err  string // The text of the error returned by the RPC system.
 }
 
 func (err *RPCError) Error() string {
-	return "rpc.php?type=" + err.Type + "&" + err.Arg + " returned '" + err.Err + "'"
+	return "rpc type=" + err.Type + " arg=" + err.Arg + " returned '" + err.Err + "'"
 }
@@ -0,0 +1,640 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAUR is a stand-in for the AUR. It serves RPC requests for the
+// packages in pkgs and source tarballs for the package bases in srcs.
+type fakeAUR struct {
+	*httptest.Server
+
+	pkgs map[string]AURPackage
+	srcs map[string]map[string]string // base -> file -> contents
+
+	m        sync.Mutex
+	requests []string // The paths and queries of every request.
+}
+
+// newFakeAUR starts a fakeAUR serving the given packages and points
+// pacgo at it using --aururl. Each package gets a source tarball
+// containing a .SRCINFO. CacheDir is set to a temporary directory so
+// that nothing is served from the cache of another test. Everything
+// is cleaned up when the test finishes.
+func newFakeAUR(t *testing.T, pkgs ...AURPackage) *fakeAUR {
+	aur := &fakeAUR{
+		pkgs: make(map[string]AURPackage, len(pkgs)),
+		srcs: make(map[string]map[string]string, len(pkgs)),
+	}
+	for _, pkg := range pkgs {
+		if pkg.PackageBase == "" {
+			pkg.PackageBase = pkg.Name
+		}
+		aur.pkgs[pkg.Name] = pkg
+
+		ver := strings.SplitN(pkg.Version, "-", 2)
+		aur.srcs[pkg.PackageBase] = map[string]string{
+			"PKGBUILD": "pkgname=" + pkg.Name + "\n",
+			".SRCINFO": "pkgbase = " + pkg.PackageBase + "\n" +
+				"\tpkgdesc = " + pkg.Description + "\n" +
+				"\tpkgver = " + ver[0] + "\n" +
+				"\tpkgrel = " + ver[1] + "\n" +
+				"\tarch = any\n" +
+				"\n" +
+				"pkgname = " + pkg.Name + "\n",
+		}
+	}
+	aur.Server = httptest.NewServer(aur)
+
+	oldURL, oldCache, oldOffline := AURURL, CacheDir, Offline
+	t.Cleanup(func() {
+		aur.Close()
+		AURURL, CacheDir, Offline = oldURL, oldCache, oldOffline
+	})
+
+	_, err := ParseGlobalFlags([]string{"--aururl", aur.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CacheDir = t.TempDir()
+	Offline = false
+
+	return aur
+}
+
+// requestCount returns the number of requests that have been made to
+// aur so far.
+func (aur *fakeAUR) requestCount() int {
+	aur.m.Lock()
+	defer aur.m.Unlock()
+
+	return len(aur.requests)
+}
+
+func (aur *fakeAUR) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	aur.m.Lock()
+	aur.requests = append(aur.requests, req.URL.RequestURI())
+	aur.m.Unlock()
+
+	switch {
+	case req.URL.Path == "/rpc/":
+		aur.serveRPC(rw, req)
+	case strings.HasPrefix(req.URL.Path, "/packages/") && strings.HasSuffix(req.URL.Path, ".tar.gz"):
+		base := strings.TrimSuffix(filepath.Base(req.URL.Path), ".tar.gz")
+		files, ok := aur.srcs[base]
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/x-gzip")
+		rw.Write(makeSourceTar(base, files))
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+func (aur *fakeAUR) serveRPC(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("v") != "5" {
+		json.NewEncoder(rw).Encode(&RPCResult{Type: "error", Error: "Invalid version specified."})
+		return
+	}
+
+	result := RPCResult{
+		Version: 5,
+		Type:    q.Get("type"),
+		Results: []AURPackage{},
+	}
+	switch q.Get("type") {
+	case "info":
+		result.Type = "multiinfo"
+		for _, name := range q["arg[]"] {
+			if pkg, ok := aur.pkgs[name]; ok {
+				result.Results = append(result.Results, pkg)
+			}
+		}
+	case "search":
+		arg := q.Get("arg")
+		for _, pkg := range aur.pkgs {
+			var match bool
+			switch q.Get("by") {
+			case "provides":
+				for _, prov := range pkg.Provides {
+					match = match || (ParseDep(prov).Name == arg)
+				}
+			default:
+				match = strings.Contains(pkg.Name, arg) || strings.Contains(pkg.Description, arg)
+			}
+			if match {
+				result.Results = append(result.Results, searchResult(pkg))
+			}
+		}
+		if len(result.Results) == 0 {
+			result.Type = "error"
+			result.Error = "No results found"
+		}
+	default:
+		result.Type = "error"
+		result.Error = "Incorrect request type specified."
+	}
+	result.ResultCount = len(result.Results)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(&result)
+}
+
+// searchResult returns the parts of pkg that the AUR includes in
+// search results. Lists, such as depends and provides, are only
+// included in info results.
+func searchResult(pkg AURPackage) AURPackage {
+	return AURPackage{
+		ID:             pkg.ID,
+		Name:           pkg.Name,
+		PackageBaseID:  pkg.PackageBaseID,
+		PackageBase:    pkg.PackageBase,
+		Version:        pkg.Version,
+		Description:    pkg.Description,
+		URL:            pkg.URL,
+		NumVotes:       pkg.NumVotes,
+		Popularity:     pkg.Popularity,
+		OutOfDate:      pkg.OutOfDate,
+		Maintainer:     pkg.Maintainer,
+		FirstSubmitted: pkg.FirstSubmitted,
+		LastModified:   pkg.LastModified,
+		URLPath:        pkg.URLPath,
+	}
+}
+
+// makeSourceTar returns a gzipped tarball containing the given files
+// in a directory named after base, like the AUR's source tarballs.
+func makeSourceTar(base string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	tw.WriteHeader(&tar.Header{Name: base + "/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, body := range files {
+		tw.WriteHeader(&tar.Header{
+			Name:     base + "/" + name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(body)),
+		})
+		tw.Write([]byte(body))
+	}
+
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestAURInfo(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1", LastModified: 1},
+	)
+
+	info, err := AURInfo("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (info.Name != "foo") || (info.Version != "1.0-1") || (info.PackageBase != "foo") {
+		t.Errorf("Got %+v", info)
+	}
+
+	_, err = AURInfo("missing")
+	if re, ok := err.(*RPCError); !ok || (re.Err != "No results found") {
+		t.Errorf("Expected *RPCError for missing package, got %v", err)
+	}
+}
+
+func TestAURInfoMulti(t *testing.T) {
+	var pkgs []AURPackage
+	var names []string
+	for i := 0; i < 500; i++ {
+		name := strings.Repeat("x", 10) + string(rune('a'+i%26)) + strings.Repeat("y", i/26)
+		pkgs = append(pkgs, AURPackage{Name: name, Version: "1-1"})
+		names = append(names, name)
+	}
+	aur := newFakeAUR(t, pkgs...)
+
+	infos, err := AURInfoMulti(append(names, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != len(names) {
+		t.Errorf("Got %v results, expected %v", len(infos), len(names))
+	}
+	if _, ok := infos["missing"]; ok {
+		t.Errorf("Got a result for a missing package")
+	}
+
+	aur.m.Lock()
+	defer aur.m.Unlock()
+	if len(aur.requests) < 2 {
+		t.Errorf("Expected the request to be split, but only %v were made", len(aur.requests))
+	}
+	for _, req := range aur.requests {
+		if len(AURURL)+len(req) > MaxRPCURLLen {
+			t.Errorf("Request is %v bytes long", len(AURURL)+len(req))
+		}
+	}
+}
+
+func TestResolveDepsAURError(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+	)
+	writeFakeDB(t, nil)
+
+	// Only the search for providers is cached, so that the info
+	// request is the only thing that fails.
+	AURSearchBy("provides", "foo")
+	Offline = true
+
+	_, err := resolveDeps([]string{"foo"})
+	if (err == nil) || !strings.Contains(err.Error(), "not cached") {
+		t.Errorf("Expected the AUR's error, got %v", err)
+	}
+}
+
+func TestAURSearch(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1", Description: "The foo tool"},
+		AURPackage{Name: "bar", Version: "1.0-1", Description: "Not related", Provides: []string{"baz=1.0"}},
+	)
+
+	results, err := AURSearch("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(results) != 1) || (results[0].Name != "foo") {
+		t.Errorf("Got %+v", results)
+	}
+
+	results, err = AURSearchBy("provides", "baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(results) != 1) || (results[0].Name != "bar") {
+		t.Errorf("Got %+v", results)
+	}
+
+	_, err = AURSearch("nothing")
+	if re, ok := err.(*RPCError); !ok || (re.Err != "No results found") {
+		t.Errorf("Expected *RPCError, got %v", err)
+	}
+}
+
+func TestAURSearchBadName(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+		AURPackage{Name: "foo-evil", PackageBase: "../../..", Version: "1.0-1"},
+		AURPackage{Name: "../foo", Version: "1.0-1"},
+	)
+
+	results, err := AURSearch("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(results) != 1) || (results[0].Name != "foo") {
+		t.Errorf("Got %+v", results)
+	}
+
+	_, err = AURInfo("foo-evil")
+	if err == nil {
+		t.Errorf("Got info for a package with a bad package base")
+	}
+	if info, _ := cachedInfo("foo-evil"); info != nil {
+		t.Errorf("Info with a bad package base was cached")
+	}
+}
+
+func TestExtractSourceTar(t *testing.T) {
+	aur := newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+		AURPackage{Name: "big", Version: "1.0-1"},
+	)
+	aur.srcs["big"]["huge"] = strings.Repeat("x", 1<<20)
+
+	dir := t.TempDir()
+	err := ExtractSourceTar(dir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", ".SRCINFO")); err != nil {
+		t.Error(err)
+	}
+
+	err = ExtractSourceTar(dir, "missing")
+	if se, ok := err.(*SourceTarError); !ok || (se.Kind != SourceNotFound) {
+		t.Errorf("Expected SourceNotFound, got %v", err)
+	}
+
+	oldMax := MaxSourceSize
+	defer func() { MaxSourceSize = oldMax }()
+	MaxSourceSize = 1 << 10
+
+	err = ExtractSourceTar(dir, "big")
+	if se, ok := err.(*SourceTarError); !ok || (se.Kind != SourceTooLarge) {
+		t.Errorf("Expected SourceTooLarge, got %v", err)
+	}
+}
+
+// TestInstallFetch checks the part of -S that happens before anything
+// is built: looking the package up and fetching its build files.
+func TestInstallFetch(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-2", LastModified: 1},
+	)
+
+	oldGit := GitPath
+	defer func() { GitPath = oldGit }()
+	GitPath = ""
+
+	info, ok := InAUR("foo")
+	if !ok {
+		t.Fatal("foo not found in the AUR")
+	}
+
+	pkg, err := NewAURPkg(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (pkg.Name() != "foo") || (pkg.pkgbuild.VersionString() != "1.0-2") {
+		t.Errorf("Got %v %v", pkg.Name(), pkg.pkgbuild.VersionString())
+	}
+	if _, err := os.Stat(filepath.Join(CacheDir, "aur", "foo", "PKGBUILD")); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeFakeDB creates a pacman.conf and database in a temporary
+// directory and points PacmanConfPath at it. local maps the names of
+// installed packages to their versions, and repo lists the packages in
+// the only sync database.
+func writeFakeDB(t *testing.T, local map[string]string, repo ...string) {
+	dir := t.TempDir()
+	dbpath := filepath.Join(dir, "db")
+
+	err := os.MkdirAll(filepath.Join(dbpath, "local"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ver := range local {
+		pkgdir := filepath.Join(dbpath, "local", name+"-"+ver)
+		err := os.MkdirAll(pkgdir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		desc := "%NAME%\n" + name + "\n\n%VERSION%\n" + ver + "\n\n"
+		err = ioutil.WriteFile(filepath.Join(pkgdir, "desc"), []byte(desc), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range repo {
+		desc := "%NAME%\n" + name + "\n\n%VERSION%\n1.0-1\n\n"
+		tw.WriteHeader(&tar.Header{Name: name + "-1.0-1/", Typeflag: tar.TypeDir, Mode: 0755})
+		tw.WriteHeader(&tar.Header{Name: name + "-1.0-1/desc", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(desc))})
+		tw.Write([]byte(desc))
+	}
+	tw.Close()
+
+	err = os.MkdirAll(filepath.Join(dbpath, "sync"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dbpath, "sync", "core.db"), buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := "[options]\nDBPath = " + dbpath + "/\nArchitecture = x86_64\n\n[core]\nServer = file:///dev/null\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "pacman.conf"), []byte(conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldConf := PacmanConfPath
+	t.Cleanup(func() { PacmanConfPath = oldConf })
+	PacmanConfPath = filepath.Join(dir, "pacman.conf")
+}
+
+// TestCheckUpdates checks how -Su finds the AUR packages to upgrade.
+func TestCheckUpdates(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+		AURPackage{Name: "same", Version: "2.0-1"},
+	)
+	writeFakeDB(t, map[string]string{
+		"foo":    "0.9-1",
+		"same":   "2.0-1",
+		"gone":   "1.0-1",
+		"native": "1.0-1",
+	}, "native")
+
+	ups, err := CheckAURUpdates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ups) != 2 {
+		t.Fatalf("Got %v updates, expected 2: %+v", len(ups), ups)
+	}
+	if (ups[0].Info.Name != "foo") || !ups[0].Newer || (ups[0].Installed != "0.9-1") {
+		t.Errorf("Got %+v", ups[0])
+	}
+	if (ups[1].Info.Name != "same") || ups[1].Newer {
+		t.Errorf("Got %+v", ups[1])
+	}
+
+	ups, err = CheckAURUpdates("foo")
+	if (err != nil) || (len(ups) != 1) {
+		t.Errorf("Got %+v, %v", ups, err)
+	}
+
+	for _, name := range []string{"native", "gone", "uninstalled"} {
+		_, err = CheckAURUpdates(name)
+		if err == nil {
+			t.Errorf("Expected an error for %v", name)
+		}
+	}
+}
+
+// git runs git in dir with the given args, failing the test if it
+// fails.
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@localhost"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// fakeGitAUR creates a bare repository for the package base foo in a
+// temporary directory and points AURURL at it. It returns the path of
+// a clone that commits can be pushed from with pushPkgbuild().
+func fakeGitAUR(t *testing.T) string {
+	if GitPath == "" {
+		t.Skip("git not found")
+	}
+
+	up := t.TempDir()
+	git(t, up, "init", "-q", "work")
+
+	oldURL := AURURL
+	t.Cleanup(func() { AURURL = oldURL })
+	AURURL = "file://" + up
+
+	return filepath.Join(up, "work")
+}
+
+// pushPkgbuild commits the given PKGBUILD in work and pushes it to
+// the repository created by fakeGitAUR().
+func pushPkgbuild(t *testing.T, work, pkgbuild string) {
+	err := ioutil.WriteFile(filepath.Join(work, "PKGBUILD"), []byte(pkgbuild), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, work, "add", "PKGBUILD")
+	git(t, work, "commit", "-q", "-m", pkgbuild)
+
+	bare := filepath.Join(filepath.Dir(work), "foo.git")
+	if _, err := os.Stat(bare); err != nil {
+		git(t, filepath.Dir(work), "clone", "-q", "--bare", "work", "foo.git")
+		return
+	}
+	git(t, work, "push", "-q", bare, "HEAD")
+}
+
+func TestFetchGitFastForward(t *testing.T) {
+	work := fakeGitAUR(t)
+	pushPkgbuild(t, work, "pkgver=1\n")
+
+	dir := t.TempDir()
+	err := fetchGit(dir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushPkgbuild(t, work, "pkgver=2\n")
+	err = fetchGit(dir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo", "PKGBUILD"))
+	if (err != nil) || (string(data) != "pkgver=2\n") {
+		t.Errorf("PKGBUILD wasn't updated: %q, %v", data, err)
+	}
+}
+
+func TestFetchGitDiverged(t *testing.T) {
+	work := fakeGitAUR(t)
+	pushPkgbuild(t, work, "pkgver=1\n")
+
+	dir := t.TempDir()
+	err := fetchGit(dir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgbuild := filepath.Join(dir, "foo", "PKGBUILD")
+	err = ioutil.WriteFile(pkgbuild, []byte("pkgver=1\n# Edited.\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushPkgbuild(t, work, "pkgver=2\n")
+	err = FetchAUR(dir, "foo")
+	if _, ok := err.(*GitDivergedError); !ok {
+		t.Errorf("Expected *GitDivergedError, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(pkgbuild)
+	if (err != nil) || (string(data) != "pkgver=1\n# Edited.\n") {
+		t.Errorf("Local changes were lost: %q, %v", data, err)
+	}
+}
+
+func TestFetchGitNotARepo(t *testing.T) {
+	work := fakeGitAUR(t)
+	pushPkgbuild(t, work, "pkgver=1\n")
+
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "foo"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "foo", "PKGBUILD"), []byte("pkgver=0\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fetchGit(dir, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo", ".git")); err != nil {
+		t.Errorf("Repository wasn't cloned: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "foo.old", "PKGBUILD"))
+	if (err != nil) || (string(data) != "pkgver=0\n") {
+		t.Errorf("Old files weren't moved aside: %q, %v", data, err)
+	}
+}
+
+func TestExtractSourceTarDropped(t *testing.T) {
+	aur := newFakeAUR(t)
+	data := makeSourceTar("foo", map[string]string{
+		"PKGBUILD": strings.Repeat("# Padding.\n", 1<<12),
+	})
+
+	// Serve half of the tarball and then drop the connection.
+	aur.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-gzip")
+		rw.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		rw.Write(data[:len(data)/2])
+	})
+
+	err := ExtractSourceTar(t.TempDir(), "foo")
+	if se, ok := err.(*SourceTarError); !ok || (se.Kind != SourceServerError) {
+		t.Errorf("Expected SourceServerError, got %v", err)
+	}
+}
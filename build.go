@@ -0,0 +1,225 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The files that are kept in the build directory of each package
+// base. makepkg itself uses src and pkg.
+const (
+	buildSources  = "sources"
+	buildPackages = "packages"
+	buildMarker   = ".building"
+
+	// buildStamp marks a directory as one that pacgo made, so that
+	// -Sc never removes anything else. Its modification time is when
+	// the directory was last used.
+	buildStamp = ".pacgo"
+)
+
+// PkgBuildDir returns the directory in BuildDir that the given package
+// base is built in and nil, or "" and an error if base isn't a valid
+// package base.
+func PkgBuildDir(base string) (string, error) {
+	err := CheckPkgName(base)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(BuildDir, base), nil
+}
+
+// buildEnv returns the environment variables that tell makepkg to
+// build in BuildDir, and to keep the sources and package files in the
+// given PkgBuildDir().
+func buildEnv(dir string) []string {
+	return []string{
+		"BUILDDIR=" + BuildDir,
+		"SRCDEST=" + filepath.Join(dir, buildSources),
+		"PKGDEST=" + filepath.Join(dir, buildPackages),
+	}
+}
+
+// startBuild prepares the PkgBuildDir() of the given package base for
+// a build of the given version, and marks the build as unfinished
+// until finishBuild() is called. It returns an error, if any.
+func startBuild(base, version string) error {
+	dir, err := PkgBuildDir(base)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{buildSources, buildPackages} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, buildStamp), []byte(base+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, buildMarker), []byte(version), 0644)
+}
+
+// finishBuild marks the build of the given package base as finished.
+func finishBuild(base string) {
+	dir, err := PkgBuildDir(base)
+	if err != nil {
+		return
+	}
+
+	os.Remove(filepath.Join(dir, buildMarker))
+}
+
+// unfinishedBuild returns true if a build of the given version of the
+// given package base was started and didn't finish, and its extracted
+// sources are still there, so that it can be resumed.
+func unfinishedBuild(base, version string) bool {
+	dir, err := PkgBuildDir(base)
+	if err != nil {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, buildMarker))
+	if (err != nil) || (string(data) != version) {
+		return false
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "src"))
+	return (err == nil) && fi.IsDir()
+}
+
+// pkgFileName returns the name of the package that the given package
+// file is for, or "" if it's not a package file. Package files are
+// named name-pkgver-pkgrel-arch.pkg.tar*.
+func pkgFileName(file string) string {
+	if !strings.Contains(file, ".pkg.tar") || strings.HasSuffix(file, ".sig") {
+		return ""
+	}
+
+	parts := strings.Split(file, "-")
+	if len(parts) < 4 {
+		return ""
+	}
+
+	return strings.Join(parts[:len(parts)-3], "-")
+}
+
+// PruneBuilds removes old files from BuildDir. The build directories
+// of package bases that haven't been used in longer than maxAge are
+// removed completely. In the others, only the newest keep package
+// files of each package are kept. Directories that pacgo didn't make
+// are left alone. The names of the removed files and directories are
+// printed. It returns an error, if any.
+func PruneBuilds(maxAge time.Duration, keep int) error {
+	if maxAge < 0 {
+		return errors.New("Can't prune builds newer than now.")
+	}
+	if keep < 0 {
+		return errors.New("Can't keep a negative number of packages.")
+	}
+
+	bases, err := ioutil.ReadDir(BuildDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, base := range bases {
+		if !base.IsDir() {
+			continue
+		}
+		dir := filepath.Join(BuildDir, base.Name())
+
+		stamp, err := os.Stat(filepath.Join(dir, buildStamp))
+		if err != nil {
+			continue
+		}
+
+		if time.Since(stamp.ModTime()) > maxAge {
+			Cprintf("removing %v...\n", dir)
+			err = os.RemoveAll(dir)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = prunePackages(filepath.Join(dir, buildPackages), keep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prunePackages removes all but the newest keep package files of each
+// package in dir, along with their signatures. It returns an error, if
+// any.
+func prunePackages(dir string, keep int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	byName := make(map[string][]os.FileInfo)
+	for _, file := range files {
+		if name := pkgFileName(file.Name()); name != "" {
+			byName[name] = append(byName[name], file)
+		}
+	}
+
+	for _, pkgs := range byName {
+		if len(pkgs) <= keep {
+			continue
+		}
+
+		sort.Slice(pkgs, func(i, j int) bool {
+			return pkgs[i].ModTime().After(pkgs[j].ModTime())
+		})
+
+		for _, file := range pkgs[keep:] {
+			path := filepath.Join(dir, file.Name())
+			Cprintf("removing %v...\n", path)
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(path + ".sig")
+		}
+	}
+
+	return nil
+}
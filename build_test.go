@@ -0,0 +1,173 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setBuildDir points BuildDir at a new temporary directory for the
+// rest of the test.
+func setBuildDir(t *testing.T) {
+	old := BuildDir
+	t.Cleanup(func() { BuildDir = old })
+	BuildDir = t.TempDir()
+}
+
+func TestPkgFileName(t *testing.T) {
+	tests := map[string]string{
+		"foo-1.0-1-x86_64.pkg.tar.zst":         "foo",
+		"foo-bar-1:1.0-1.1-any.pkg.tar.xz":     "foo-bar",
+		"foo-bar-1.0-1-x86_64.pkg.tar.zst.sig": "",
+		"foo-1.0.tar.gz":                       "",
+		"1-1-any.pkg.tar.zst":                  "",
+	}
+	for file, name := range tests {
+		if got := pkgFileName(file); got != name {
+			t.Errorf("pkgFileName(%q) = %q, expected %q", file, got, name)
+		}
+	}
+}
+
+func TestUnfinishedBuild(t *testing.T) {
+	setBuildDir(t)
+
+	err := startBuild("foo", "1.0-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unfinishedBuild("foo", "1.0-1") {
+		t.Errorf("Build without extracted sources can be resumed")
+	}
+
+	err = os.Mkdir(filepath.Join(BuildDir, "foo", "src"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unfinishedBuild("foo", "1.0-1") {
+		t.Errorf("Unfinished build not detected")
+	}
+	if unfinishedBuild("foo", "1.1-1") {
+		t.Errorf("Unfinished build of a different version can be resumed")
+	}
+
+	finishBuild("foo")
+	if unfinishedBuild("foo", "1.0-1") {
+		t.Errorf("Finished build can be resumed")
+	}
+}
+
+func TestPruneBuilds(t *testing.T) {
+	setBuildDir(t)
+	old := time.Now().Add(-48 * time.Hour)
+
+	for _, base := range []string{"new", "old"} {
+		err := startBuild(base, "1.0-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		finishBuild(base)
+	}
+	err := os.Chtimes(filepath.Join(BuildDir, "old", buildStamp), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not made by pacgo.
+	other := filepath.Join(BuildDir, "other")
+	err = os.Mkdir(other, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(other, old, old)
+
+	pkgs := filepath.Join(BuildDir, "new", buildPackages)
+	files := []string{
+		"new-1.0-1-any.pkg.tar.zst",
+		"new-1.0-1-any.pkg.tar.zst.sig",
+		"new-1.1-1-any.pkg.tar.zst",
+		"new-1.2-1-any.pkg.tar.zst",
+	}
+	for i, file := range files {
+		path := filepath.Join(pkgs, file)
+		err := ioutil.WriteFile(path, nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mtime := time.Now().Add(time.Duration(i-len(files)) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	err = PruneBuilds(-time.Hour, 1)
+	if err == nil {
+		t.Errorf("Negative age accepted")
+	}
+
+	err = PruneBuilds(24*time.Hour, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(BuildDir, "old")); !os.IsNotExist(err) {
+		t.Errorf("Old build wasn't removed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Directory not made by pacgo was removed: %v", err)
+	}
+
+	left, err := ioutil.ReadDir(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(left) != 1) || (left[0].Name() != "new-1.2-1-any.pkg.tar.zst") {
+		var names []string
+		for _, fi := range left {
+			names = append(names, fi.Name())
+		}
+		t.Errorf("Got packages %v", names)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", "/home/test")
+
+	tests := map[string]string{
+		"~/build":       "/home/test/build",
+		"$HOME/build/":  "/home/test/build",
+		"/var/tmp/pkgs": "/var/tmp/pkgs",
+	}
+	for path, expected := range tests {
+		got, err := expandPath(path)
+		if (err != nil) || (got != expected) {
+			t.Errorf("expandPath(%q) = %q, %v; expected %q", path, got, err, expected)
+		}
+	}
+
+	for _, path := range []string{"build", "", "./build"} {
+		if _, err := expandPath(path); err == nil {
+			t.Errorf("expandPath(%q) accepted a relative path", path)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrOffline is returned when something that isn't cached is needed
+// while running with --offline.
+var ErrOffline = errors.New("Not available offline.")
+
+// cacheEntry is a cached HTTP response.
+type cacheEntry struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Fetched      int64
+	Body         []byte
+}
+
+// httpCachePath returns the path of the cache file for the given url.
+func httpCachePath(u string) string {
+	return filepath.Join(CacheDir, "rpc", fmt.Sprintf("%x", sha1.Sum([]byte(u))))
+}
+
+// readCacheFile decodes the JSON in the file at path into v. It
+// returns an error, if any.
+func readCacheFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// writeCacheFile encodes v as JSON into the file at path. The file is
+// replaced atomically, so that concurrent readers never see it half
+// written. It returns an error, if any.
+func writeCacheFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// fresh returns true if something fetched at the given Unix time can
+// still be used without checking with the server.
+func fresh(fetched int64) bool {
+	return Offline || (time.Since(time.Unix(fetched, 0)) < CacheTTL)
+}
+
+// CachedGet gets the body of the given url, going through the cache
+// in CacheDir. Responses newer than CacheTTL are used without asking
+// the server. Older ones are revalidated using their ETag and
+// Last-Modified headers. With --offline, anything that's cached is
+// used, and ErrOffline is returned for anything that isn't. It
+// returns the body and nil, or nil and an error, if any.
+func CachedGet(u string) ([]byte, error) {
+	path := httpCachePath(u)
+
+	var entry cacheEntry
+	cached := (readCacheFile(path, &entry) == nil) && (entry.URL == u)
+	if cached && fresh(entry.Fetched) {
+		return entry.Body, nil
+	}
+	if Offline {
+		return nil, ErrOffline
+	}
+
+	header := make(http.Header)
+	if cached {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	rsp, err := HTTPGetWith(u, header)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	switch {
+	case cached && (rsp.StatusCode == http.StatusNotModified):
+		entry.Fetched = time.Now().Unix()
+	case rsp.StatusCode == http.StatusOK:
+		body, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = cacheEntry{
+			URL:          u,
+			ETag:         rsp.Header.Get("ETag"),
+			LastModified: rsp.Header.Get("Last-Modified"),
+			Fetched:      time.Now().Unix(),
+			Body:         body,
+		}
+	default:
+		return nil, fmt.Errorf("Request failed: %v", rsp.Status)
+	}
+
+	err = writeCacheFile(path, &entry)
+	if err != nil {
+		Cprintf("[c6]warning:[ce] Failed to cache %v: %v\n", u, err)
+	}
+
+	return entry.Body, nil
+}
+
+// PruneCache removes the cached RPC responses and package info in
+// CacheDir that are older than CacheTTL. It returns the number of
+// files removed and nil, or the number removed so far and an error, if
+// any.
+func PruneCache() (int, error) {
+	var n int
+	for _, dir := range []string{filepath.Join(CacheDir, "rpc"), filepath.Join(CacheDir, "rpc", "info")} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return n, err
+		}
+
+		for _, file := range files {
+			// Temporary files might still be being written.
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".tmp-") {
+				continue
+			}
+			path := filepath.Join(dir, file.Name())
+
+			var entry struct {
+				Fetched int64
+			}
+			err := readCacheFile(path, &entry)
+			if (err == nil) && (time.Since(time.Unix(entry.Fetched, 0)) < CacheTTL) {
+				continue
+			}
+
+			err = os.Remove(path)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// infoCacheEntry is the cached info for a single AUR package. Info is
+// nil if the package isn't in the AUR.
+type infoCacheEntry struct {
+	Fetched int64
+	Name    string
+	Info    *AURPackage
+}
+
+// infoCachePath returns the path of the cache file for the named
+// package's info and nil, or "" and an error if name isn't a valid
+// package name.
+func infoCachePath(name string) (string, error) {
+	err := CheckPkgName(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(CacheDir, "rpc", "info", name), nil
+}
+
+// cachedInfo returns the cached info for the named package and true,
+// or nil and false if it's not cached or is too old to be used. If the
+// package is cached as not being in the AUR, it returns nil and true.
+func cachedInfo(name string) (*AURPackage, bool) {
+	path, err := infoCachePath(name)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry infoCacheEntry
+	err = readCacheFile(path, &entry)
+	if (err != nil) || (entry.Name != name) || !fresh(entry.Fetched) {
+		return nil, false
+	}
+
+	return entry.Info, true
+}
+
+// cacheInfo caches the given info for the named package. info should
+// be nil if the package isn't in the AUR.
+func cacheInfo(name string, info *AURPackage) {
+	path, err := infoCachePath(name)
+	if err == nil {
+		err = writeCacheFile(path, &infoCacheEntry{
+			Fetched: time.Now().Unix(),
+			Name:    name,
+			Info:    info,
+		})
+	}
+	if err != nil {
+		Cprintf("[c6]warning:[ce] Failed to cache info for %v: %v\n", name, err)
+	}
+}
+
+// srcStampPath returns the path of the file that records which
+// version of the given package base's build files are in its
+// AURSrcDir().
+func srcStampPath(base string) (string, error) {
+	dir, err := AURSrcDir(base)
+	if err != nil {
+		return "", err
+	}
+
+	return dir + ".lastmodified", nil
+}
+
+// srcUpToDate returns true if the build files in the AURSrcDir() for
+// the package described by info were fetched for the same
+// LastModified time as info, so they don't need to be fetched again.
+// With --offline, any build files that have been fetched are used.
+func srcUpToDate(info *AURPackage) bool {
+	base := info.PackageBase
+	if base == "" {
+		base = info.Name
+	}
+
+	dir, err := AURSrcDir(base)
+	if err != nil {
+		return false
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "PKGBUILD")); err != nil {
+		return false
+	}
+	if Offline {
+		return true
+	}
+
+	stamp, err := srcStampPath(base)
+	if err != nil {
+		return false
+	}
+
+	data, err := ioutil.ReadFile(stamp)
+	if err != nil {
+		return false
+	}
+
+	return string(data) == fmt.Sprint(info.LastModified)
+}
+
+// markSrcFetched records that the build files for the package
+// described by info have been fetched.
+func markSrcFetched(info *AURPackage) {
+	base := info.PackageBase
+	if base == "" {
+		base = info.Name
+	}
+
+	path, err := srcStampPath(base)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(fmt.Sprint(info.LastModified)), 0644)
+	}
+	if err != nil {
+		Cprintf("[c6]warning:[ce] Failed to record fetch of %v: %v\n", base, err)
+	}
+}
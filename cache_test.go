@@ -0,0 +1,265 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setCacheTTL sets CacheTTL for the rest of the test.
+func setCacheTTL(t *testing.T, ttl time.Duration) {
+	old := CacheTTL
+	t.Cleanup(func() { CacheTTL = old })
+	CacheTTL = ttl
+}
+
+func TestCachedGet(t *testing.T) {
+	var m sync.Mutex
+	var full, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+
+		switch req.URL.Path {
+		case "/etag":
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+			rw.Header().Set("ETag", `"v1"`)
+		case "/modified":
+			if req.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+				notModified++
+				rw.WriteHeader(http.StatusNotModified)
+				return
+			}
+			rw.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		}
+
+		full++
+		rw.Write([]byte("body of " + req.URL.Path))
+	}))
+	defer srv.Close()
+
+	counts := func() (int, int) {
+		m.Lock()
+		defer m.Unlock()
+		return full, notModified
+	}
+
+	oldCache, oldOffline := CacheDir, Offline
+	defer func() { CacheDir, Offline = oldCache, oldOffline }()
+	CacheDir = t.TempDir()
+	Offline = false
+
+	for _, path := range []string{"/etag", "/modified"} {
+		full, notModified = 0, 0
+		u := srv.URL + path
+
+		setCacheTTL(t, time.Hour)
+		for i := 0; i < 2; i++ {
+			body, err := CachedGet(u)
+			if (err != nil) || (string(body) != "body of "+path) {
+				t.Fatalf("%v: Got %q, %v", path, body, err)
+			}
+		}
+		if f, nm := counts(); (f != 1) || (nm != 0) {
+			t.Errorf("%v: Fresh entry wasn't used: %v full and %v not modified responses", path, f, nm)
+		}
+
+		CacheTTL = 0
+		body, err := CachedGet(u)
+		if (err != nil) || (string(body) != "body of "+path) {
+			t.Fatalf("%v: Got %q, %v", path, body, err)
+		}
+		if f, nm := counts(); (f != 1) || (nm != 1) {
+			t.Errorf("%v: Stale entry wasn't revalidated: %v full and %v not modified responses", path, f, nm)
+		}
+	}
+
+	full, notModified = 0, 0
+	Offline = true
+	body, err := CachedGet(srv.URL + "/etag")
+	if (err != nil) || (string(body) != "body of /etag") {
+		t.Errorf("Offline: Got %q, %v", body, err)
+	}
+	_, err = CachedGet(srv.URL + "/uncached")
+	if err != ErrOffline {
+		t.Errorf("Offline: Expected ErrOffline, got %v", err)
+	}
+	if f, nm := counts(); (f != 0) || (nm != 0) {
+		t.Errorf("Offline: Made %v requests", f+nm)
+	}
+}
+
+func TestAURInfoCache(t *testing.T) {
+	aur := newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+	)
+	setCacheTTL(t, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		infos, err := AURInfoMulti([]string{"foo", "missing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := infos["foo"]; !ok || (len(infos) != 1) {
+			t.Errorf("Got %v", infos)
+		}
+	}
+	if n := aur.requestCount(); n != 1 {
+		t.Errorf("Made %v requests, expected 1", n)
+	}
+	if _, err := os.Stat(httpCachePath(RPCURL("info", "foo", "missing"))); err == nil {
+		t.Errorf("Info was also cached by URL")
+	}
+
+	Offline = true
+	infos, err := AURInfoMulti([]string{"foo", "missing"})
+	if (err != nil) || (len(infos) != 1) {
+		t.Errorf("Offline: Got %v, %v", infos, err)
+	}
+	_, err = AURInfoMulti([]string{"unknown"})
+	if err == nil {
+		t.Errorf("Offline: Expected an error for a package that was never looked up")
+	}
+	if n := aur.requestCount(); n != 1 {
+		t.Errorf("Offline: Made %v requests", n-1)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+	)
+	setCacheTTL(t, time.Hour)
+
+	_, err := AURSearch("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AURInfo("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := PruneCache()
+	if (err != nil) || (n != 0) {
+		t.Errorf("Pruned %v fresh entries: %v", n, err)
+	}
+
+	setCacheTTL(t, 0)
+	n, err = PruneCache()
+	if (err != nil) || (n != 2) {
+		t.Errorf("Pruned %v expired entries, expected 2: %v", n, err)
+	}
+	if _, err := os.Stat(httpCachePath(RPCURL("search", "foo"))); err == nil {
+		t.Errorf("Expired search wasn't pruned")
+	}
+	if path, _ := infoCachePath("foo"); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("Expired info wasn't pruned")
+		}
+	}
+}
+
+// TestCheckUpdatesOffline checks that -Su --offline works when a
+// foreign package isn't in the AUR.
+func TestCheckUpdatesOffline(t *testing.T) {
+	aur := newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1"},
+	)
+	writeFakeDB(t, map[string]string{
+		"foo":   "0.9-1",
+		"local": "1.0-1",
+	})
+	setCacheTTL(t, time.Hour)
+
+	_, err := CheckAURUpdates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := aur.requestCount()
+
+	Offline = true
+	ups, err := CheckAURUpdates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(ups) != 1) || (ups[0].Info.Name != "foo") || !ups[0].Newer {
+		t.Errorf("Got %+v", ups)
+	}
+	if aur.requestCount() != n {
+		t.Errorf("Made requests while offline")
+	}
+}
+
+func TestSrcCache(t *testing.T) {
+	aur := newFakeAUR(t,
+		AURPackage{Name: "foo", Version: "1.0-1", LastModified: 1},
+	)
+
+	oldGit := GitPath
+	defer func() { GitPath = oldGit }()
+	GitPath = ""
+
+	info := aur.pkgs["foo"]
+	_, err := NewAURPkg(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := aur.requestCount()
+
+	// FetchAUR() only fetches each package base once per run.
+	fetches.Lock()
+	fetches.m = make(map[string]*fetch)
+	fetches.Unlock()
+
+	_, err = NewAURPkg(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aur.requestCount() != n {
+		t.Errorf("Build files were fetched again without being modified")
+	}
+
+	Offline = true
+	info.LastModified = 2
+	_, err = NewAURPkg(&info)
+	if err != nil {
+		t.Errorf("Offline: %v", err)
+	}
+	if aur.requestCount() != n {
+		t.Errorf("Offline: Build files were fetched")
+	}
+
+	Offline = false
+	_, err = NewAURPkg(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aur.requestCount() == n {
+		t.Errorf("Build files weren't fetched after being modified")
+	}
+}
@@ -20,7 +20,9 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -37,12 +39,21 @@ var (
 )
 
 func init() {
+	SetupColor()
+}
+
+// SetupColor enables colored output if stdout is a terminal and Color
+// is set in pacman.conf, and disables it otherwise.
+func SetupColor() {
+	Color1, Color2, Color3, Color4 = "", "", "", ""
+	Color5, Color6, Color7, ColorEnd = "", "", "", ""
+
 	if !IsTerminal(int(os.Stdout.Fd())) {
 		return
 	}
 
-	color, err := CheckConfOption("Color")
-	if !color || (err != nil) {
+	conf, err := PacmanConfig()
+	if (err != nil) || !conf.Color {
 		return
 	}
 
@@ -82,11 +93,94 @@ func Cprintf(s string, args ...interface{}) (int, error) {
 	return fmt.Print(Colorize(fmt.Sprintf(s, args...)))
 }
 
+// AskCategory is a kind of question asked by Caskf(). The answers to
+// all of the questions in a category can be given ahead of time with
+// --ask.
+type AskCategory string
+
+const (
+	// Whether or not to install packages.
+	AskInstall AskCategory = "install"
+
+	// Whether or not to review and edit build files.
+	AskEdit AskCategory = "edit"
+
+	// Whether or not to use previously built packages.
+	AskCached AskCategory = "cached"
+
+	// Whether or not to resume builds that didn't finish.
+	AskResume AskCategory = "resume"
+
+	// Whether or not to clean up pacgo's files.
+	AskClean AskCategory = "clean"
+)
+
+var (
+	// If NoConfirm is true, every question is answered with its
+	// default answer. It's set by --noconfirm.
+	NoConfirm bool
+
+	// Answers holds the answers given for each category by --ask.
+	Answers = make(map[AskCategory]bool)
+
+	// All questions read from stdin using the same reader so that
+	// nothing that's been buffered for one is lost for the next.
+	stdin = bufio.NewReader(os.Stdin)
+)
+
+// Interactive returns true if questions are actually asked. This is
+// the case unless --noconfirm was given or stdin isn't a terminal.
+func Interactive() bool {
+	return !NoConfirm && IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Preanswered returns true if questions in the given category aren't
+// answered by someone at the terminal, either because an answer was
+// given with --ask or because pacgo isn't interactive. Asking such a
+// question again always gives the same answer.
+func Preanswered(cat AskCategory) bool {
+	_, ok := Answers[cat]
+	return ok || !Interactive()
+}
+
+// ParseAsk parses the value of an --ask flag, which is a comma
+// separated list of category=answer pairs, such as
+// 'install=yes,edit=no', and adds the answers to Answers. It returns
+// an error, if any.
+func ParseAsk(val string) error {
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Expected category=answer: %v", pair)
+		}
+
+		cat := AskCategory(parts[0])
+		switch cat {
+		case AskInstall, AskEdit, AskCached, AskResume, AskClean:
+		default:
+			return fmt.Errorf("Unknown question category: %v", cat)
+		}
+
+		switch strings.ToLower(parts[1]) {
+		case "y", "yes":
+			Answers[cat] = true
+		case "n", "no":
+			Answers[cat] = false
+		default:
+			return fmt.Errorf("Expected yes or no: %v", parts[1])
+		}
+	}
+
+	return nil
+}
+
 // Caskf prints the given question, in color, appending the
 // apporopriate question prompt to the end of it ([Y/n] or [y/N]). def
-// is the default answer. It returns the result and nil, or false and
-// an error, if any.
-func Caskf(def bool, col string, s string, args ...interface{}) (bool, error) {
+// is the default answer. If an answer was given for the question's
+// category with --ask, it's used. Otherwise, if pacgo isn't
+// interactive, the default is used. It returns the result and nil, or
+// false and an error, if any.
+func Caskf(cat AskCategory, def bool, col string, s string, args ...interface{}) (bool, error) {
 	q := fmt.Sprintf(" %v[y/N][ce] ", col)
 	if def {
 		q = fmt.Sprintf(" %v[Y/n][ce] ", col)
@@ -94,18 +188,68 @@ func Caskf(def bool, col string, s string, args ...interface{}) (bool, error) {
 
 	Cprintf(s+q, args...)
 
-	bufin := bufio.NewReader(os.Stdin)
-	c, err := bufin.ReadByte()
-	if err != nil {
+	if answer, ok := Answers[cat]; ok {
+		if answer {
+			fmt.Println("y")
+		} else {
+			fmt.Println("n")
+		}
+		return answer, nil
+	}
+
+	if !Interactive() {
+		fmt.Println()
+		return def, nil
+	}
+
+	line, err := stdin.ReadString('\n')
+	if (err != nil) && ((err != io.EOF) || (line == "")) {
 		return false, err
 	}
+	line = strings.TrimSpace(line)
 
-	switch unicode.ToLower(rune(c)) {
-	case 'y':
-		def = true
-	case 'n':
-		def = false
+	if line != "" {
+		switch unicode.ToLower(rune(line[0])) {
+		case 'y':
+			def = true
+		case 'n':
+			def = false
+		}
 	}
 
 	return def, nil
 }
+
+// Cnumberf prints the given prompt, in color, and then reads a number
+// between 1 and max, inclusive. def is the default answer, which is
+// used without asking if pacgo isn't interactive. If an invalid
+// number is entered, it asks again. It returns the result and nil, or
+// def and an error, if any.
+func Cnumberf(def, max int, s string, args ...interface{}) (int, error) {
+	for {
+		Cprintf(s+" (default=%v): ", append(args, def)...)
+
+		if !Interactive() {
+			fmt.Println()
+			return def, nil
+		}
+
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return def, err
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			return def, nil
+		}
+
+		n, err := strconv.Atoi(line)
+		if (err != nil) || (n < 1) || (n > max) {
+			Cprintf("[c7]error:[ce] invalid number: %v\n", line)
+			continue
+		}
+
+		return n, nil
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAURURL is the AUR that is used if no other is configured.
+const DefaultAURURL = "https://aur.archlinux.org"
+
+// DefaultMaxSourceSize is the default value of MaxSourceSize.
+const DefaultMaxSourceSize = 64 << 20
+
+// DefaultCacheTTL is the default value of CacheTTL.
+const DefaultCacheTTL = 10 * time.Minute
+
+const (
+	// DefaultBuildKeep is the default value of BuildKeep.
+	DefaultBuildKeep = 1
+
+	// DefaultBuildMaxAge is the default value of BuildMaxAge.
+	DefaultBuildMaxAge = 30 * 24 * time.Hour
+)
+
+var (
+	// The base URL of the AUR, or an AUR-compatible mirror. It can be
+	// set using the AURURL option in the config file, the
+	// PACGO_AUR_URL environment variable, or the --aururl flag, in
+	// increasing order of precedence.
+	AURURL = DefaultAURURL
+
+	// The maximum size, in bytes, of a source tarball, both as it's
+	// downloaded and once it's uncompressed. It can be set using the
+	// MaxSourceSize option in the config file.
+	MaxSourceSize int64 = DefaultMaxSourceSize
+
+	// How long responses from the AUR are used from the cache before
+	// checking if they're still up to date. It can be set using the
+	// CacheTTL option in the config file.
+	CacheTTL = DefaultCacheTTL
+
+	// The number of package files that -Sc keeps for each AUR package.
+	// It can be set using the BuildKeep option in the config file.
+	BuildKeep = DefaultBuildKeep
+
+	// How long the build directory of a package base is kept by -Sc
+	// after it was last used. It can be set using the BuildMaxAge
+	// option in the config file.
+	BuildMaxAge = DefaultBuildMaxAge
+
+	// If true, nothing is fetched from the AUR. Only cached responses
+	// and build files are used. Set by the --offline flag.
+	Offline bool
+)
+
+// ConfigPath returns the path of pacgo's config file. Usually
+// $XDG_CONFIG_HOME/pacgo/config.
+func ConfigPath() string {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "pacgo", "config")
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".config", "pacgo", "config")
+}
+
+// expandPath expands environment variables and a leading ~ in path.
+// It returns the expanded path and nil, or "" and an error if it
+// isn't absolute.
+func expandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+	if (path == "~") || strings.HasPrefix(path, "~/") {
+		path = filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%v is not an absolute path.", path)
+	}
+
+	return filepath.Clean(path), nil
+}
+
+// LoadConfig reads pacgo's config file, if it exists, and then
+// applies any settings from the environment. The config file consists
+// of 'Key = Value' lines. Blank lines and lines starting with '#' are
+// ignored. It returns an error, if any.
+func LoadConfig() error {
+	file, err := os.Open(ConfigPath())
+	if err == nil {
+		defer file.Close()
+
+		lines, err := ReadLines(file, true)
+		if err != nil {
+			return err
+		}
+
+		for i, line := range lines {
+			if (len(line) == 0) || (line[0] == '#') {
+				continue
+			}
+
+			parts := bytes.SplitN(line, []byte{'='}, 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%v:%v: Expected 'Key = Value'.", ConfigPath(), i+1)
+			}
+
+			key := string(bytes.TrimSpace(parts[0]))
+			val := string(bytes.TrimSpace(parts[1]))
+			switch key {
+			case "AURURL":
+				AURURL = val
+			case "CARCH":
+				CARCHOverride = val
+			case "MaxSourceSize":
+				size, err := strconv.ParseInt(val, 10, 64)
+				if (err != nil) || (size <= 0) {
+					return fmt.Errorf("%v:%v: MaxSourceSize must be a positive number of bytes.", ConfigPath(), i+1)
+				}
+				MaxSourceSize = size
+			case "CacheTTL":
+				ttl, err := time.ParseDuration(val)
+				if (err != nil) || (ttl < 0) {
+					return fmt.Errorf("%v:%v: CacheTTL must be a duration, such as 10m.", ConfigPath(), i+1)
+				}
+				CacheTTL = ttl
+			case "BuildDir":
+				dir, err := expandPath(val)
+				if err != nil {
+					return fmt.Errorf("%v:%v: BuildDir: %v", ConfigPath(), i+1, err)
+				}
+				BuildDir = dir
+			case "BuildKeep":
+				keep, err := strconv.Atoi(val)
+				if (err != nil) || (keep < 0) {
+					return fmt.Errorf("%v:%v: BuildKeep must be a number of packages.", ConfigPath(), i+1)
+				}
+				BuildKeep = keep
+			case "BuildMaxAge":
+				age, err := time.ParseDuration(val)
+				if (err != nil) || (age < 0) {
+					return fmt.Errorf("%v:%v: BuildMaxAge must be a duration, such as 720h.", ConfigPath(), i+1)
+				}
+				BuildMaxAge = age
+			default:
+				return fmt.Errorf("%v:%v: Unknown option: %v", ConfigPath(), i+1, key)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if env := os.Getenv("PACGO_AUR_URL"); env != "" {
+		AURURL = env
+	}
+	if env := os.Getenv("CARCH"); env != "" {
+		CARCHOverride = env
+	}
+
+	AURURL = strings.TrimRight(AURURL, "/")
+
+	return nil
+}
+
+// ParseGlobalFlags removes flags that apply to all commands from the
+// start of args and applies them. Scanning stops at the command, since
+// anything after it belongs to the command, which may pass it on to
+// pacman or makepkg, where flags like --config mean something else.
+// It returns the remaining args and nil, or nil and an error, if any.
+func ParseGlobalFlags(args []string) ([]string, error) {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			return append(rest, args[i:]...), nil
+		case arg == "--aururl":
+			if i+1 >= len(args) {
+				return nil, &UsageError{arg}
+			}
+			i++
+			AURURL = strings.TrimRight(args[i], "/")
+		case strings.HasPrefix(arg, "--aururl="):
+			AURURL = strings.TrimRight(arg[len("--aururl="):], "/")
+		case arg == "--config":
+			if i+1 >= len(args) {
+				return nil, &UsageError{arg}
+			}
+			i++
+			PacmanConfPath = args[i]
+		case strings.HasPrefix(arg, "--config="):
+			PacmanConfPath = arg[len("--config="):]
+		case arg == "--offline":
+			Offline = true
+		case arg == "--noconfirm":
+			NoConfirm = true
+		case arg == "--ask":
+			if i+1 >= len(args) {
+				return nil, &UsageError{arg}
+			}
+			i++
+			err := ParseAsk(args[i])
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--ask="):
+			err := ParseAsk(arg[len("--ask="):])
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return append(rest, args[i:]...), nil
+		}
+	}
+
+	return rest, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	oldURL, oldConf := AURURL, PacmanConfPath
+	defer func() {
+		AURURL, PacmanConfPath = oldURL, oldConf
+	}()
+
+	args, err := ParseGlobalFlags([]string{
+		"--config", "/etc/pacman-alt.conf",
+		"--aururl=https://aur.example.com/",
+		"-M", "--config", "/etc/makepkg-alt.conf", "-s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-M", "--config", "/etc/makepkg-alt.conf", "-s"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Got args %q, expected %q", args, want)
+	}
+	if PacmanConfPath != "/etc/pacman-alt.conf" {
+		t.Errorf("Got --config %q", PacmanConfPath)
+	}
+	if AURURL != "https://aur.example.com" {
+		t.Errorf("Got --aururl %q", AURURL)
+	}
+
+	_, err = ParseGlobalFlags([]string{"--config"})
+	if _, ok := err.(*UsageError); !ok {
+		t.Errorf("Expected *UsageError for missing value, got %v", err)
+	}
+}
+
+func TestParseGlobalFlagsAsk(t *testing.T) {
+	oldNoConfirm, oldAnswers := NoConfirm, Answers
+	defer func() {
+		NoConfirm, Answers = oldNoConfirm, oldAnswers
+	}()
+	NoConfirm = false
+	Answers = make(map[AskCategory]bool)
+
+	// pacman's and makepkg's own --noconfirm and --ask, which takes a
+	// number, are left to them.
+	args, err := ParseGlobalFlags([]string{
+		"--ask=edit=no",
+		"-M", "--noconfirm", "--ask", "4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-M", "--noconfirm", "--ask", "4"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("Got args %q, expected %q", args, want)
+	}
+	if NoConfirm {
+		t.Errorf("--noconfirm after the command was applied")
+	}
+	if answer, ok := Answers[AskEdit]; !ok || answer {
+		t.Errorf("Got answers %v", Answers)
+	}
+
+	_, err = ParseGlobalFlags([]string{"--noconfirm", "--ask"})
+	if _, ok := err.(*UsageError); !ok {
+		t.Errorf("Expected *UsageError for missing value, got %v", err)
+	}
+}
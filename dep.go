@@ -0,0 +1,117 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dep represents a dependency on a package, such as 'foo>=1.2'.
+type Dep struct {
+	Name string
+
+	// Op is the comparison operator of the version constraint. It's
+	// one of "<", "<=", "=", ">=", and ">", or "" if the dependency
+	// isn't constrained.
+	Op      string
+	Version string
+}
+
+// depOps are the operators that can be used in a Dep. Longer ones
+// need to come first so that they get matched properly.
+var depOps = []string{"<=", ">=", "<", ">", "="}
+
+// ParseDep parses a dependency string, such as one from a PKGBUILD's
+// depends array.
+func ParseDep(str string) Dep {
+	i := strings.IndexAny(str, "<>=")
+	if i < 0 {
+		return Dep{Name: str}
+	}
+
+	dep := Dep{Name: str[:i]}
+	for _, op := range depOps {
+		if strings.HasPrefix(str[i:], op) {
+			dep.Op = op
+			dep.Version = str[i+len(op):]
+			break
+		}
+	}
+
+	return dep
+}
+
+func (dep Dep) String() string {
+	return dep.Name + dep.Op + dep.Version
+}
+
+// SatisfiedBy returns true, nil if a package with the given version
+// would satisfy dep, else it returns false, nil. If any errors occur
+// it returns false and an error.
+func (dep Dep) SatisfiedBy(ver string) (bool, error) {
+	if dep.Op == "" {
+		return true, nil
+	}
+
+	c, err := Vercmp(ver, dep.Version)
+	if err != nil {
+		return false, err
+	}
+
+	switch dep.Op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "=":
+		return c == 0, nil
+	case ">=":
+		return c >= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+
+	return false, fmt.Errorf("Bad dependency operator: %v", dep.Op)
+}
+
+// SatisfiedByPkg is like SatisfiedBy(), but it checks the version of
+// the given Pkg. The name of the package is not checked.
+func (dep Dep) SatisfiedByPkg(pkg Pkg) (bool, error) {
+	if dep.Op == "" {
+		return true, nil
+	}
+
+	ver, err := pkg.Version()
+	if err != nil {
+		return false, err
+	}
+
+	return dep.SatisfiedBy(ver)
+}
+
+// UnsatisfiedDepError is returned when a package needed for a
+// dependency was found, but not in a version that satisfies it.
+type UnsatisfiedDepError struct {
+	Dep     Dep
+	Version string // The version that was found.
+}
+
+func (err *UnsatisfiedDepError) Error() string {
+	return fmt.Sprintf("Unable to satisfy %v: Found version %v.", err.Dep, err.Version)
+}
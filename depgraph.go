@@ -0,0 +1,285 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DepGraph is a graph of packages and their dependencies. It contains
+// a set of root packages and the transitive closure of the
+// dependencies of the packages in it that need to be built, such as
+// *AURPkgs. Other packages are included, but their dependencies are
+// left to pacman.
+type DepGraph struct {
+	nodes map[string]*depNode
+
+	// The keys of the nodes in the order in which they were added. This
+	// keeps the order of sorts stable.
+	keys []string
+}
+
+type depNode struct {
+	pkg  Pkg
+	root bool
+	deps []string
+
+	// The first package that was found to depend on this one.
+	requiredBy Pkg
+}
+
+// depKey returns the key used for pkg in a DepGraph.
+func depKey(pkg Pkg) string {
+	return fmt.Sprintf("%T/%v", pkg, pkg.Name())
+}
+
+// depsErrer is implemented by Pkgs that can fail to resolve their
+// dependencies.
+type depsErrer interface {
+	depsError() error
+}
+
+// NewDepGraph returns a new *DepGraph containing the given packages
+// and their dependencies and nil, or nil and an error, if any.
+func NewDepGraph(roots PkgList) (*DepGraph, error) {
+	g := &DepGraph{
+		nodes: make(map[string]*depNode),
+	}
+
+	var queue PkgList
+	for _, pkg := range roots {
+		if n, ok := g.add(pkg, nil); ok {
+			queue = append(queue, n.pkg)
+		}
+		g.nodes[depKey(pkg)].root = true
+	}
+
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+
+		switch pkg.(type) {
+		case *AURPkg, *PkgbuildPkg:
+		default:
+			continue
+		}
+
+		deps := pkg.Deps()
+		if de, ok := pkg.(depsErrer); ok {
+			if err := de.depsError(); err != nil {
+				return nil, fmt.Errorf("%v: %v", pkg.Name(), err)
+			}
+		}
+
+		n := g.nodes[depKey(pkg)]
+		for _, dep := range deps {
+			if dn, ok := g.add(dep, pkg); ok {
+				queue = append(queue, dn.pkg)
+			}
+
+			n.deps = append(n.deps, depKey(dep))
+		}
+	}
+
+	return g, nil
+}
+
+// add adds pkg to g as a dependency of requiredBy, which may be nil.
+// It returns the package's node and true if it was newly added, or
+// the existing node and false if it was already in g.
+func (g *DepGraph) add(pkg Pkg, requiredBy Pkg) (*depNode, bool) {
+	key := depKey(pkg)
+	if n, ok := g.nodes[key]; ok {
+		return n, false
+	}
+
+	n := &depNode{
+		pkg:        pkg,
+		requiredBy: requiredBy,
+	}
+	g.nodes[key] = n
+	g.keys = append(g.keys, key)
+
+	return n, true
+}
+
+// IsRoot returns true if pkg was one of the packages that g was
+// created with.
+func (g *DepGraph) IsRoot(pkg Pkg) bool {
+	n, ok := g.nodes[depKey(pkg)]
+	return ok && n.root
+}
+
+// RequiredBy returns the first package in g that was found to depend
+// on pkg, or nil if there isn't one.
+func (g *DepGraph) RequiredBy(pkg Pkg) Pkg {
+	if n, ok := g.nodes[depKey(pkg)]; ok {
+		return n.requiredBy
+	}
+
+	return nil
+}
+
+// DepsOf returns the packages in g that pkg depends on directly.
+func (g *DepGraph) DepsOf(pkg Pkg) PkgList {
+	n, ok := g.nodes[depKey(pkg)]
+	if !ok {
+		return nil
+	}
+
+	pl := make(PkgList, 0, len(n.deps))
+	for _, key := range n.deps {
+		pl = append(pl, g.nodes[key].pkg)
+	}
+
+	return pl
+}
+
+// MergeBases merges the nodes of *AURPkgs that are built from the same
+// package base into the node of the first one that was added, since
+// they're built together. The merged node depends on everything that
+// any of them depend on, so that the order that Sort() returns and
+// DepsOf() take all of them into account. The other packages are
+// added to the first one's extra list. Their nodes are kept so that
+// IsRoot() and RequiredBy() still work for them, but Sort() no longer
+// returns them.
+func (g *DepGraph) MergeBases() {
+	first := make(map[string]string)
+	merged := make(map[string]string)
+	keys := make([]string, 0, len(g.keys))
+	for _, key := range g.keys {
+		n := g.nodes[key]
+		ap, ok := n.pkg.(*AURPkg)
+		if !ok {
+			keys = append(keys, key)
+			continue
+		}
+
+		fkey, ok := first[ap.Base()]
+		if !ok {
+			first[ap.Base()] = key
+			keys = append(keys, key)
+			continue
+		}
+
+		fn := g.nodes[fkey]
+		fp := fn.pkg.(*AURPkg)
+		fp.extra = append(fp.extra, ap)
+		fn.deps = append(fn.deps, n.deps...)
+		merged[key] = fkey
+	}
+	if len(merged) == 0 {
+		return
+	}
+	g.keys = keys
+
+	for _, key := range keys {
+		n := g.nodes[key]
+
+		seen := make(map[string]bool, len(n.deps))
+		deps := make([]string, 0, len(n.deps))
+		for _, dep := range n.deps {
+			if to, ok := merged[dep]; ok {
+				dep = to
+			}
+			if (dep == key) || seen[dep] {
+				continue
+			}
+
+			seen[dep] = true
+			deps = append(deps, dep)
+		}
+		n.deps = deps
+	}
+}
+
+// Sort returns every package in g, ordered so that each package comes
+// after all of its dependencies, and nil. If the packages depend on
+// each other in a cycle, it returns nil and a *DepCycleError.
+func (g *DepGraph) Sort() (PkgList, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(g.nodes))
+	order := make(PkgList, 0, len(g.nodes))
+	var stack []string
+
+	var visit func(key string) error
+	visit = func(key string) error {
+		switch state[key] {
+		case visited:
+			return nil
+		case visiting:
+			for i := range stack {
+				if stack[i] == key {
+					return g.cycleError(append(stack[i:], key))
+				}
+			}
+		}
+
+		state[key] = visiting
+		stack = append(stack, key)
+
+		for _, dep := range g.nodes[key].deps {
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[key] = visited
+		order = append(order, g.nodes[key].pkg)
+
+		return nil
+	}
+
+	for _, key := range g.keys {
+		err := visit(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (g *DepGraph) cycleError(keys []string) error {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, g.nodes[key].pkg.Name())
+	}
+
+	return &DepCycleError{names}
+}
+
+// DepCycleError is returned when packages depend on each other in a
+// cycle.
+type DepCycleError struct {
+	// The names of the packages in the cycle, starting and ending with
+	// the same package.
+	Cycle []string
+}
+
+func (err *DepCycleError) Error() string {
+	return "Dependency cycle detected: " + strings.Join(err.Cycle, " -> ")
+}
@@ -0,0 +1,82 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+// testAURPkg returns an *AURPkg with the given name and package base
+// that doesn't need the AUR.
+func testAURPkg(name, base string) *AURPkg {
+	return &AURPkg{
+		info:     &AURPackage{Name: name, PackageBase: base},
+		pkgbuild: &Pkgbuild{Name: name, Base: base},
+	}
+}
+
+// testDepGraph builds a *DepGraph directly from the given roots and
+// dependencies, without resolving anything.
+func testDepGraph(roots PkgList, deps map[Pkg]PkgList) *DepGraph {
+	g := &DepGraph{
+		nodes: make(map[string]*depNode),
+	}
+	for _, pkg := range roots {
+		n, _ := g.add(pkg, nil)
+		n.root = true
+	}
+	for _, pkg := range roots {
+		for _, dep := range deps[pkg] {
+			g.add(dep, pkg)
+			n := g.nodes[depKey(pkg)]
+			n.deps = append(n.deps, depKey(dep))
+		}
+	}
+
+	return g
+}
+
+func TestMergeBases(t *testing.T) {
+	first := testAURPkg("split-a", "split")
+	second := testAURPkg("split-b", "split")
+	dep := testAURPkg("dep", "dep")
+
+	g := testDepGraph(PkgList{first, second}, map[Pkg]PkgList{
+		second: {dep, first},
+	})
+	g.MergeBases()
+
+	order, err := g.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(order) != 2) || (order[0] != dep) || (order[1] != first) {
+		t.Fatalf("Got order %v", order)
+	}
+	if (len(first.extra) != 1) || (first.extra[0] != second) {
+		t.Errorf("Got extra %v", first.extra)
+	}
+
+	deps := g.DepsOf(first)
+	if (len(deps) != 1) || (deps[0] != dep) {
+		t.Errorf("Got deps %v", deps)
+	}
+	if !g.IsRoot(second) {
+		t.Errorf("Merged package is no longer a root")
+	}
+}
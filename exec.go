@@ -18,10 +18,12 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 // These store the paths to the various executables that are run by
@@ -30,6 +32,7 @@ var (
 	PacmanPath  string
 	MakepkgPath string
 	VercmpPath  string
+	GitPath     string
 
 	// Using sudo?
 	Sudo       bool
@@ -38,6 +41,11 @@ var (
 	EditPath string
 
 	BashPath string
+
+	// These are optional. They're used for reviewing changes to build
+	// files.
+	DiffPath  string
+	PagerPath string
 )
 
 func init() {
@@ -57,12 +65,13 @@ func init() {
 		os.Exit(1)
 	}
 
-	// Find vercmp.
-	VercmpPath, err = exec.LookPath("vercmp")
-	if err != nil {
-		Cprintf("[c7]error:[ce] Could not find vercmp.\n")
-		os.Exit(1)
-	}
+	// Find vercmp. It's optional, since versions are compared
+	// without it.
+	VercmpPath, _ = exec.LookPath("vercmp")
+
+	// Find git. It's optional, since AUR packages can still be
+	// fetched as tarballs from some mirrors.
+	GitPath, _ = exec.LookPath("git")
 
 	// Find sudo. If you can't find it, use su.
 	AsRootPath, err = exec.LookPath("sudo")
@@ -97,6 +106,33 @@ func init() {
 	if err != nil {
 		Cprintf("[c7]error:[ce] Could not find bash.\n")
 	}
+
+	// Find diff.
+	DiffPath, _ = exec.LookPath("diff")
+
+	// Find the pager. Try the $PAGER environment variable first, and
+	// then less. If neither can be found, output isn't paged.
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	PagerPath, _ = exec.LookPath(pager)
+}
+
+// pacmanCmdline returns the command line for running pacman with
+// the given args. If a pacman.conf other than the default is being
+// used, it's passed to pacman with --config, and --noconfirm is passed
+// through if it was given.
+func pacmanCmdline(args ...string) []string {
+	cmdline := []string{PacmanPath}
+	if PacmanConfPath != DefaultPacmanConfPath {
+		cmdline = append(cmdline, "--config", PacmanConfPath)
+	}
+	if NoConfirm {
+		cmdline = append(cmdline, "--noconfirm")
+	}
+
+	return append(cmdline, args...)
 }
 
 // Pacman runs pacman, passing the given argus to it. It returns an
@@ -104,7 +140,7 @@ func init() {
 func Pacman(args ...string) error {
 	cmd := &exec.Cmd{
 		Path: PacmanPath,
-		Args: append([]string{PacmanPath}, args...),
+		Args: pacmanCmdline(args...),
 
 		Stdout: os.Stdout,
 		Stdin:  os.Stdin,
@@ -120,7 +156,7 @@ func Pacman(args ...string) error {
 func SilentPacman(args ...string) error {
 	cmd := &exec.Cmd{
 		Path: PacmanPath,
-		Args: append([]string{PacmanPath}, args...),
+		Args: pacmanCmdline(args...),
 	}
 
 	return cmd.Run()
@@ -131,7 +167,7 @@ func SilentPacman(args ...string) error {
 func PacmanOutput(args ...string) ([]byte, error) {
 	cmd := &exec.Cmd{
 		Path: PacmanPath,
-		Args: append([]string{PacmanPath}, args...),
+		Args: pacmanCmdline(args...),
 	}
 
 	return cmd.Output()
@@ -144,7 +180,7 @@ func PacmanOutput(args ...string) ([]byte, error) {
 func PacmanLines(trim bool, args ...string) ([][]byte, error) {
 	cmd := &exec.Cmd{
 		Path: PacmanPath,
-		Args: append([]string{PacmanPath}, args...),
+		Args: pacmanCmdline(args...),
 	}
 
 	out, err := cmd.StdoutPipe()
@@ -171,12 +207,25 @@ func PacmanLines(trim bool, args ...string) ([][]byte, error) {
 }
 
 // MakepkgIn runs makepkg in the given dir, passing the given args to
-// it. It returns an error, if any.
+// it, along with --noconfirm if it was given. It returns an error, if
+// any.
 func MakepkgIn(dir string, args ...string) error {
+	return MakepkgEnvIn(dir, nil, args...)
+}
+
+// MakepkgEnvIn is like MakepkgIn(), but it also adds the given
+// environment variables, in the form 'key=value', to makepkg's
+// environment.
+func MakepkgEnvIn(dir string, env []string, args ...string) error {
+	if NoConfirm {
+		args = append(args[:len(args):len(args)], "--noconfirm")
+	}
+
 	cmd := &exec.Cmd{
 		Path: MakepkgPath,
 		Args: append([]string{MakepkgPath}, args...),
 		Dir:  dir,
+		Env:  append(os.Environ(), env...),
 
 		Stdout: os.Stdout,
 		Stdin:  os.Stdin,
@@ -186,6 +235,97 @@ func MakepkgIn(dir string, args ...string) error {
 	return cmd.Run()
 }
 
+// MakepkgOutput runs makepkg in the given dir, passing the given
+// args to it, and returns its output and an error, if any.
+func MakepkgOutput(dir string, args ...string) ([]byte, error) {
+	cmd := &exec.Cmd{
+		Path: MakepkgPath,
+		Args: append([]string{MakepkgPath}, args...),
+		Dir:  dir,
+	}
+
+	return cmd.Output()
+}
+
+// GitIn runs git in the given dir, passing the given args to it. It
+// returns an error, if any.
+func GitIn(dir string, args ...string) error {
+	if GitPath == "" {
+		return errors.New("Could not find git.")
+	}
+
+	cmd := &exec.Cmd{
+		Path: GitPath,
+		Args: append([]string{GitPath}, args...),
+		Dir:  dir,
+
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	return cmd.Run()
+}
+
+// GitOutput runs git in the given dir, passing the given args to it,
+// and returns its output and an error, if any.
+func GitOutput(dir string, args ...string) ([]byte, error) {
+	if GitPath == "" {
+		return nil, errors.New("Could not find git.")
+	}
+
+	cmd := &exec.Cmd{
+		Path: GitPath,
+		Args: append([]string{GitPath}, args...),
+		Dir:  dir,
+	}
+
+	return cmd.Output()
+}
+
+// DiffOutput runs diff, passing the given args to it, and returns its
+// output and an error, if any. Unlike most commands, diff exits with
+// a status of 1 if the files are different, so that isn't considered
+// an error.
+func DiffOutput(args ...string) ([]byte, error) {
+	if DiffPath == "" {
+		return nil, errors.New("Could not find diff.")
+	}
+
+	cmd := &exec.Cmd{
+		Path: DiffPath,
+		Args: append([]string{DiffPath}, args...),
+	}
+
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok && (ws.ExitStatus() == 1) {
+			err = nil
+		}
+	}
+
+	return out, err
+}
+
+// Page shows the given text using the pager. If there is no pager, it
+// just prints it. It returns an error, if any.
+func Page(text []byte) error {
+	if PagerPath == "" {
+		_, err := os.Stdout.Write(text)
+		return err
+	}
+
+	cmd := &exec.Cmd{
+		Path: PagerPath,
+		Args: []string{PagerPath},
+
+		Stdout: os.Stdout,
+		Stdin:  bytes.NewReader(text),
+		Stderr: os.Stderr,
+	}
+
+	return cmd.Run()
+}
+
 // VercmpOutput runs vercmp, passing the given args to it. It returns
 // its output and an error, if any.
 func VercmpOutput(args ...string) ([]byte, error) {
@@ -204,7 +344,7 @@ func AsRootPacman(args ...string) error {
 		return errors.New("Could not find sudo or su.")
 	}
 
-	args = append([]string{PacmanPath}, args...)
+	args = pacmanCmdline(args...)
 
 	var cmdargs []string
 	if Sudo {
@@ -25,9 +25,11 @@ func init() {
 	RegisterCmd("-G", &Cmd{
 		Help:      "Download the PKGBUILDs and other files for AUR packages.",
 		UsageLine: "-G <pkgname>...",
-		HelpMore: `-G downloads the source tarball for the given package(s) and extracts
-them to the current directory. It accepts no arguments other than
-package names, and will skip packages when it encounters errors.
+		HelpMore: `-G clones the git repositories for the given package(s) into the
+current directory, or fast-forwards them if they've already been
+cloned. If git isn't available, it downloads and extracts the source
+tarballs instead. It accepts no arguments other than package names,
+and will skip packages when it encounters errors.
 `,
 		Run: func(args ...string) error {
 			if len(args) == 1 {
@@ -43,24 +45,34 @@ package names, and will skip packages when it encounters errors.
 				}
 			}
 
+			infos, err := AURInfoMulti(pkgs)
+			if err != nil {
+				return err
+			}
+
 			var wg sync.WaitGroup
 			for _, pkg := range pkgs {
+				info, ok := infos[pkg]
+				if !ok {
+					Cprintf("[c6]warning:[ce] %v was not found in the AUR. Skipping...\n", pkg)
+					continue
+				}
+
+				base := info.PackageBase
+				if base == "" {
+					base = info.Name
+				}
+
 				wg.Add(1)
-				go func(pkg string) {
+				go func(pkg, base string) {
 					defer wg.Done()
 
-					tr, err := GetSourceTar(pkg)
-					if err != nil {
-						Cprintf("[c6]warning:[ce] Failed to get source tar for %v. Skipping...\n", pkg)
-						return
-					}
-
-					err = ExtractTar(".", tr)
+					err := FetchAUR(".", base)
 					if err != nil {
-						Cprintf("[c6]warning:[ce] Failed to extract %v. Skipping...\n", pkg)
+						Cprintf("[c6]warning:[ce] Failed to fetch %v (%v). Skipping...\n", pkg, err)
 						return
 					}
-				}(pkg)
+				}(pkg, base)
 			}
 
 			wg.Wait()
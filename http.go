@@ -0,0 +1,215 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// UserAgent is sent with every HTTP request that pacgo makes.
+const UserAgent = "pacgo (+https://github.com/DeedleFake/pacgo)"
+
+// HTTPRetries is the number of times that a request is retried after
+// a transient failure.
+const HTTPRetries = 4
+
+var (
+	// HTTPBackoff is the delay before the first retry. It doubles
+	// after each one. No delay is ever longer than HTTPMaxBackoff, even
+	// if the server asks for a longer one.
+	HTTPBackoff    = 500 * time.Millisecond
+	HTTPMaxBackoff = 8 * time.Second
+
+	// HTTPReadTimeout is how long a response can go without sending
+	// any data before the request is abandoned.
+	HTTPReadTimeout = 30 * time.Second
+)
+
+// HTTPClient is used for every HTTP request that pacgo makes. Proxies
+// are configured using the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+// environment variables.
+var HTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   15 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: HTTPReadTimeout,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// HTTPGet gets the given url using HTTPClient. Network errors that are
+// likely to be temporary, as well as 5xx and 429 responses, are
+// retried up to HTTPRetries times, backing off exponentially between
+// attempts. If the server sends a Retry-After header with a number of
+// seconds, that's waited instead, but no wait is ever longer than
+// HTTPMaxBackoff. If the retries run out, the last response or error
+// is returned. Reading the response's body fails if the server stops
+// sending data for HTTPReadTimeout. It returns the response and nil,
+// or nil and an error, if any.
+func HTTPGet(u string) (*http.Response, error) {
+	return HTTPGetWith(u, nil)
+}
+
+// HTTPGetBody gets the body of the given url using HTTPGet(). Any
+// response other than 200 OK is an error. It returns the body and
+// nil, or nil and an error, if any.
+func HTTPGetBody(u string) ([]byte, error) {
+	rsp, err := HTTPGet(u)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request failed: %v", rsp.Status)
+	}
+
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// HTTPGetWith is like HTTPGet(), but it also sends the given headers,
+// which may be nil.
+func HTTPGetWith(u string, header http.Header) (*http.Response, error) {
+	backoff := HTTPBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("User-Agent", UserAgent)
+
+		rsp, err := HTTPClient.Do(req)
+
+		var retry bool
+		wait := backoff
+		switch {
+		case err != nil:
+			retry = transientError(err)
+		case (rsp.StatusCode >= 500) || (rsp.StatusCode == http.StatusTooManyRequests):
+			retry = true
+			if secs, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && (secs >= 0) {
+				wait = time.Duration(secs) * time.Second
+			}
+		}
+
+		if !retry || (attempt >= HTTPRetries) {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+
+			rsp.Body = newIdleTimeoutBody(rsp.Body, cancel)
+			return rsp, nil
+		}
+
+		if rsp != nil {
+			io.Copy(ioutil.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+		cancel()
+
+		if wait > HTTPMaxBackoff {
+			wait = HTTPMaxBackoff
+		}
+		time.Sleep(wait)
+
+		backoff *= 2
+	}
+}
+
+// transientError returns true if err is a network error that might go
+// away if the request is tried again.
+func transientError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	var dnse *net.DNSError
+	if errors.As(err, &dnse) && dnse.Temporary() {
+		return true
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	return false
+}
+
+// idleTimeoutBody wraps a response body, cancelling its request if
+// nothing is read from it for HTTPReadTimeout.
+type idleTimeoutBody struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+
+	m     sync.Mutex
+	timer *time.Timer
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, cancel context.CancelFunc) *idleTimeoutBody {
+	return &idleTimeoutBody{
+		body:   body,
+		cancel: cancel,
+		timer:  time.AfterFunc(HTTPReadTimeout, cancel),
+	}
+}
+
+func (b *idleTimeoutBody) Read(buf []byte) (int, error) {
+	n, err := b.body.Read(buf)
+
+	b.m.Lock()
+	b.timer.Reset(HTTPReadTimeout)
+	b.m.Unlock()
+
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.m.Lock()
+	b.timer.Stop()
+	b.m.Unlock()
+
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
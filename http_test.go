@@ -0,0 +1,270 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer is a stand-in server that fails the first fails
+// requests made to it using fail, and then responds with "ok".
+type flakyServer struct {
+	*httptest.Server
+
+	fails int
+	fail  func(rw http.ResponseWriter, req *http.Request)
+
+	m     sync.Mutex
+	times []time.Time // When each request was received.
+}
+
+func newFlakyServer(t *testing.T, fails int, fail func(rw http.ResponseWriter, req *http.Request)) *flakyServer {
+	s := &flakyServer{
+		fails: fails,
+		fail:  fail,
+	}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *flakyServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.m.Lock()
+	s.times = append(s.times, time.Now())
+	n := len(s.times)
+	s.m.Unlock()
+
+	if n <= s.fails {
+		s.fail(rw, req)
+		return
+	}
+
+	rw.Write([]byte("ok"))
+}
+
+// requests returns the number of requests that s has received.
+func (s *flakyServer) requests() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.times)
+}
+
+// gap returns the time between the first and second requests that s
+// received.
+func (s *flakyServer) gap() time.Duration {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.times[1].Sub(s.times[0])
+}
+
+// setHTTPTimes sets the HTTP backoff and timeout settings for the rest
+// of the test.
+func setHTTPTimes(t *testing.T, backoff, max, read time.Duration) {
+	oldBackoff, oldMax, oldRead := HTTPBackoff, HTTPMaxBackoff, HTTPReadTimeout
+	t.Cleanup(func() {
+		HTTPBackoff, HTTPMaxBackoff, HTTPReadTimeout = oldBackoff, oldMax, oldRead
+	})
+
+	HTTPBackoff, HTTPMaxBackoff, HTTPReadTimeout = backoff, max, read
+}
+
+// getBody gets u using HTTPGet() and returns the status and body.
+func getBody(t *testing.T, u string) (int, string) {
+	rsp, err := HTTPGet(u)
+	if err != nil {
+		t.Fatalf("HTTPGet: %v", err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatalf("Reading body: %v", err)
+	}
+
+	return rsp.StatusCode, string(body)
+}
+
+func TestHTTPGetRetries(t *testing.T) {
+	setHTTPTimes(t, time.Millisecond, 10*time.Millisecond, time.Second)
+
+	tests := []struct {
+		name  string
+		fails int
+		fail  func(rw http.ResponseWriter, req *http.Request)
+	}{
+		{
+			name:  "500",
+			fails: 2,
+			fail: func(rw http.ResponseWriter, req *http.Request) {
+				http.Error(rw, "oops", http.StatusInternalServerError)
+			},
+		},
+		{
+			name:  "503",
+			fails: HTTPRetries,
+			fail: func(rw http.ResponseWriter, req *http.Request) {
+				http.Error(rw, "busy", http.StatusServiceUnavailable)
+			},
+		},
+		{
+			name:  "429",
+			fails: 1,
+			fail: func(rw http.ResponseWriter, req *http.Request) {
+				http.Error(rw, "slow down", http.StatusTooManyRequests)
+			},
+		},
+		{
+			name:  "connection reset",
+			fails: 2,
+			fail: func(rw http.ResponseWriter, req *http.Request) {
+				conn, _, err := rw.(http.Hijacker).Hijack()
+				if err != nil {
+					panic(err)
+				}
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newFlakyServer(t, test.fails, test.fail)
+
+			status, body := getBody(t, s.URL)
+			if (status != http.StatusOK) || (body != "ok") {
+				t.Errorf("Got %v %q", status, body)
+			}
+			if s.requests() != test.fails+1 {
+				t.Errorf("Made %v requests, expected %v", s.requests(), test.fails+1)
+			}
+		})
+	}
+}
+
+func TestHTTPGetGivesUp(t *testing.T) {
+	setHTTPTimes(t, time.Millisecond, 10*time.Millisecond, time.Second)
+
+	s := newFlakyServer(t, HTTPRetries+10, func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "down", http.StatusBadGateway)
+	})
+
+	status, _ := getBody(t, s.URL)
+	if status != http.StatusBadGateway {
+		t.Errorf("Got %v, expected the last response", status)
+	}
+	if s.requests() != HTTPRetries+1 {
+		t.Errorf("Made %v requests, expected %v", s.requests(), HTTPRetries+1)
+	}
+}
+
+func TestHTTPGetNoRetry(t *testing.T) {
+	setHTTPTimes(t, time.Millisecond, 10*time.Millisecond, time.Second)
+
+	s := newFlakyServer(t, 1, http.NotFound)
+
+	status, _ := getBody(t, s.URL)
+	if status != http.StatusNotFound {
+		t.Errorf("Got %v", status)
+	}
+	if s.requests() != 1 {
+		t.Errorf("Made %v requests, expected 1", s.requests())
+	}
+}
+
+func TestHTTPGetRetryAfter(t *testing.T) {
+	tooMany := func(retryAfter string) func(rw http.ResponseWriter, req *http.Request) {
+		return func(rw http.ResponseWriter, req *http.Request) {
+			if retryAfter != "" {
+				rw.Header().Set("Retry-After", retryAfter)
+			}
+			http.Error(rw, "slow down", http.StatusTooManyRequests)
+		}
+	}
+
+	t.Run("without", func(t *testing.T) {
+		setHTTPTimes(t, 50*time.Millisecond, time.Second, time.Second)
+
+		s := newFlakyServer(t, 1, tooMany(""))
+		getBody(t, s.URL)
+		if gap := s.gap(); gap < 50*time.Millisecond {
+			t.Errorf("Retried after %v, expected the backoff", gap)
+		}
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		setHTTPTimes(t, 5*time.Second, 5*time.Second, time.Second)
+
+		s := newFlakyServer(t, 1, tooMany("0"))
+		getBody(t, s.URL)
+		if gap := s.gap(); gap > time.Second {
+			t.Errorf("Retried after %v, expected Retry-After to be used", gap)
+		}
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		setHTTPTimes(t, time.Millisecond, 50*time.Millisecond, time.Second)
+
+		s := newFlakyServer(t, 1, tooMany("3600"))
+		getBody(t, s.URL)
+		if gap := s.gap(); (gap < 50*time.Millisecond) || (gap > time.Second) {
+			t.Errorf("Retried after %v, expected HTTPMaxBackoff", gap)
+		}
+	})
+}
+
+func TestHTTPGetIdleTimeout(t *testing.T) {
+	setHTTPTimes(t, time.Millisecond, 10*time.Millisecond, 100*time.Millisecond)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	s := newFlakyServer(t, 1, func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("partial"))
+		rw.(http.Flusher).Flush()
+
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	})
+
+	rsp, err := HTTPGet(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	start := time.Now()
+	_, err = ioutil.ReadAll(rsp.Body)
+	if err == nil {
+		t.Errorf("Reading a stalled body succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Reading a stalled body took %v", elapsed)
+	}
+}
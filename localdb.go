@@ -0,0 +1,213 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultDBPath is the location of pacman's databases if pacman.conf
+// doesn't specify one.
+const DefaultDBPath = "/var/lib/pacman/"
+
+// DBPath returns the location of pacman's databases.
+func DBPath() string {
+	conf, err := PacmanConfig()
+	if err != nil {
+		return DefaultDBPath
+	}
+
+	return conf.DBPath
+}
+
+// parseDesc parses a desc file from one of pacman's databases. These
+// consist of sections that start with a %NAME% line and end with a
+// blank line. It returns a map of section names, without the %s, to
+// their lines and nil, or nil and an error, if any.
+func parseDesc(r io.Reader) (map[string][]string, error) {
+	lines, err := ReadLines(r, true)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := make(map[string][]string)
+	var section string
+	for _, line := range lines {
+		switch {
+		case len(line) == 0:
+			section = ""
+		case (section == "") && (len(line) > 2) && (line[0] == '%') && (line[len(line)-1] == '%'):
+			section = string(line[1 : len(line)-1])
+			desc[section] = nil
+		case section != "":
+			desc[section] = append(desc[section], string(line))
+		}
+	}
+
+	return desc, nil
+}
+
+// descValue returns the first line of the named section in desc, or
+// "" if there isn't one.
+func descValue(desc map[string][]string, section string) string {
+	if vals := desc[section]; len(vals) > 0 {
+		return vals[0]
+	}
+
+	return ""
+}
+
+// LocalDBPkg represents an installed package, as read from pacman's
+// local database.
+type LocalDBPkg struct {
+	Name        string
+	Version     string
+	Base        string
+	Description string
+
+	// Reason is the reason the package was installed. It's 0 for
+	// explicitly installed packages and 1 for dependencies.
+	Reason int
+
+	InstallDate time.Time
+
+	Depends    []string
+	OptDepends []string
+	Provides   []string
+	Conflicts  []string
+	Replaces   []string
+	Groups     []string
+}
+
+// IsDep returns true if the package was installed as a dependency.
+func (p *LocalDBPkg) IsDep() bool {
+	return p.Reason == 1
+}
+
+// LocalDB is pacman's local database of installed packages.
+type LocalDB struct {
+	pkgs map[string]*LocalDBPkg
+}
+
+// ReadLocalDB reads the local database under the given DBPath. It
+// returns the database and nil, or nil and an error, if any.
+func ReadLocalDB(dbpath string) (*LocalDB, error) {
+	dirs, err := filepath.Glob(filepath.Join(dbpath, "local", "*", "desc"))
+	if err != nil {
+		return nil, err
+	}
+
+	db := &LocalDB{
+		pkgs: make(map[string]*LocalDBPkg, len(dirs)),
+	}
+	for _, path := range dirs {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		desc, err := parseDesc(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		pkg := &LocalDBPkg{
+			Name:        descValue(desc, "NAME"),
+			Version:     descValue(desc, "VERSION"),
+			Base:        descValue(desc, "BASE"),
+			Description: descValue(desc, "DESC"),
+			Depends:     desc["DEPENDS"],
+			OptDepends:  desc["OPTDEPENDS"],
+			Provides:    desc["PROVIDES"],
+			Conflicts:   desc["CONFLICTS"],
+			Replaces:    desc["REPLACES"],
+			Groups:      desc["GROUPS"],
+		}
+		if reason, err := strconv.Atoi(descValue(desc, "REASON")); err == nil {
+			pkg.Reason = reason
+		}
+		if date, err := strconv.ParseInt(descValue(desc, "INSTALLDATE"), 10, 64); err == nil {
+			pkg.InstallDate = time.Unix(date, 0)
+		}
+
+		db.pkgs[pkg.Name] = pkg
+	}
+
+	return db, nil
+}
+
+// Get returns the named package and true, or nil and false if it
+// isn't installed.
+func (db *LocalDB) Get(name string) (*LocalDBPkg, bool) {
+	pkg, ok := db.pkgs[name]
+	return pkg, ok
+}
+
+// Names returns the sorted names of every installed package.
+func (db *LocalDB) Names() []string {
+	names := make([]string, 0, len(db.pkgs))
+	for name := range db.pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+var localDB struct {
+	sync.Mutex
+	db      *LocalDB
+	modTime time.Time
+}
+
+// LocalDatabase returns the local database under DBPath(). The
+// database is cached, but it's reread if it's been changed, such as
+// by installing a package. It returns the database and nil, or nil
+// and an error, if any.
+func LocalDatabase() (*LocalDB, error) {
+	dbpath := DBPath()
+
+	fi, err := os.Stat(filepath.Join(dbpath, "local"))
+	if err != nil {
+		return nil, err
+	}
+
+	localDB.Lock()
+	defer localDB.Unlock()
+
+	if (localDB.db != nil) && fi.ModTime().Equal(localDB.modTime) {
+		return localDB.db, nil
+	}
+
+	db, err := ReadLocalDB(dbpath)
+	if err != nil {
+		return nil, err
+	}
+
+	localDB.db = db
+	localDB.modTime = fi.ModTime()
+
+	return db, nil
+}
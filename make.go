@@ -19,36 +19,55 @@ package main
 
 import (
 	"errors"
-	"os"
 )
 
+// parseAllowBash removes the --allowbash flag from args. It returns
+// whether or not the flag was found and the remaining args.
+func parseAllowBash(args []string) (bool, []string) {
+	var found bool
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--allowbash" {
+			found = true
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	return found, rest
+}
+
 func init() {
 	RegisterCmd("-M", &Cmd{
 		Help:      "Drop in replacement for makepkg with AUR support.",
-		UsageLine: "-M [makepkg opts]",
+		UsageLine: "-M [--allowbash] [makepkg opts]",
 		HelpMore: `-M scans a PKGBUILD for AUR dependencies, installs them, and then runs
 makepkg with the given arguments. Note that, since it's supposed to be
 a drop in replacement for makepkg, it will not install AUR
 dependencies unless given the -s (or --syncdeps) flag.
+
+The dependencies are read from ./.SRCINFO. If it doesn't exist, -M
+will fail unless given the --allowbash flag, in which case the
+PKGBUILD is run using bash to read them.
 `,
 		Run: func(args ...string) error {
-			file, err := os.Open("PKGBUILD")
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+			allowBash, args := parseAllowBash(args[1:])
 
-			pb, err := ParsePkgbuild(file)
+			pbs, err := LoadPkgbuild("PKGBUILD", allowBash)
 			if err != nil {
 				return errors.New("Error parsing PKGBUILD: " + err.Error())
 			}
+			if len(pbs) == 0 {
+				return errors.New("PKGBUILD doesn't build any packages.")
+			}
 
-			pkg, err := NewPkgbuildPkg(pb)
+			pkg, err := NewPkgbuildPkg(pbs[0], pbs[1:]...)
 			if err != nil {
 				return err
 			}
 
-			err = pkg.Install(nil, args[1:]...)
+			err = pkg.Install(nil, args...)
 			if err != nil {
 				return err
 			}
@@ -59,38 +78,40 @@ dependencies unless given the -s (or --syncdeps) flag.
 
 	RegisterCmd("-Mi", &Cmd{
 		Help:      "Print pacman-like info message about local PKGBUILDs.",
-		UsageLine: "-Mi [PKGBUILDs...]",
+		UsageLine: "-Mi [--allowbash] [PKGBUILDs...]",
 		HelpMore: `-Mi scans PKGBUILDs, defaulting to ./PKGBUILD if none are specified,
-and prints pacman -Qi like info about them.
+and prints pacman -Qi like info about them. For split packages, info
+is printed for each package that the PKGBUILD builds.
+
+The info is read from the .SRCINFO next to each PKGBUILD. If one
+doesn't exist, the PKGBUILD is skipped unless the --allowbash flag is
+given, in which case it is run using bash to read the info.
 `,
 		Run: func(args ...string) error {
-			if len(args) == 1 {
+			allowBash, args := parseAllowBash(args[1:])
+			if len(args) == 0 {
 				args = append(args, "PKGBUILD")
 			}
 
-			for _, arg := range args[1:] {
-				file, err := os.Open(arg)
-				if err != nil {
-					Cprintf("[c7]error:[ce] %v\n", err)
-					continue
-				}
-
-				pb, err := ParsePkgbuild(file)
+			for _, arg := range args {
+				pbs, err := LoadPkgbuild(arg, allowBash)
 				if err != nil {
 					Cprintf("[c7]error:[ce] Failed to parse %v: %v\n", arg, err)
 					continue
 				}
 
-				pkg, err := NewPkgbuildPkg(pb)
-				if err != nil {
-					Cprintf("[c7]error:[ce] %v\n", err)
-					continue
-				}
+				for _, pb := range pbs {
+					pkg, err := NewPkgbuildPkg(pb)
+					if err != nil {
+						Cprintf("[c7]error:[ce] %v\n", err)
+						continue
+					}
 
-				err = pkg.Info()
-				if err != nil {
-					Cprintf("[c7]error:[ce] %v\n", err)
-					continue
+					err = pkg.Info()
+					if err != nil {
+						Cprintf("[c7]error:[ce] %v\n", err)
+						continue
+					}
 				}
 			}
 
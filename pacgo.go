@@ -77,11 +77,34 @@ func GetCmd(arg string) *Cmd {
 }
 
 var (
-	// The temporary directory for building AUR packages. Usually
-	// /tmp/(arg0)-(uid)
+	// The temporary directory for files that don't need to outlive
+	// the system's uptime. Usually /tmp/(arg0)-(uid)
 	TmpDir string
+
+	// The directory for files that should persist between runs, such
+	// as the git repositories of AUR packages. Usually
+	// $XDG_CACHE_HOME/pacgo.
+	CacheDir string
+
+	// The directory that AUR packages are built in, and that their
+	// sources and built package files are kept in. Usually
+	// CacheDir/build.
+	BuildDir string
 )
 
+// findCacheDir returns the path that CacheDir should be set to.
+func findCacheDir() string {
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "pacgo")
+	}
+
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".cache", "pacgo")
+	}
+
+	return filepath.Join(TmpDir, "cache")
+}
+
 // MkTmpDir creates a new temporary directory for the given package
 // as a subdirectory of TmpDir. It returns the full path of the new
 // dir and an error, if any. Note that it always returns the path the
@@ -103,7 +126,7 @@ func Usage(of string) {
 		fmt.Printf("Usage: %v %v\n\n", os.Args[0], cmd.UsageLine)
 		fmt.Printf(cmd.HelpMore)
 	} else {
-		fmt.Printf("Usage: %v <cmd> [options]\n", os.Args[0])
+		fmt.Printf("Usage: %v [global options] <cmd> [options]\n", os.Args[0])
 
 		fmt.Println("Commands:")
 		tabw := tabwriter.NewWriter(os.Stdout, 2, 2, 2, ' ', 0)
@@ -111,6 +134,15 @@ func Usage(of string) {
 			fmt.Fprintf(tabw, "  %v:\t%v\n", cmd.name, cmd.cmd.Help)
 		}
 		tabw.Flush()
+
+		fmt.Println("Global options, which must come before <cmd>:")
+		tabw = tabwriter.NewWriter(os.Stdout, 2, 2, 2, ' ', 0)
+		fmt.Fprintf(tabw, "  --aururl <url>:\tUse the AUR at <url>. Default: %v\n", DefaultAURURL)
+		fmt.Fprintf(tabw, "  --config <path>:\tUse the pacman.conf at <path>. Default: %v\n", DefaultPacmanConfPath)
+		fmt.Fprintf(tabw, "  --offline:\tDon't contact the AUR. Only use what's cached.\n")
+		fmt.Fprintf(tabw, "  --noconfirm:\tDon't ask any questions. Use the default answers.\n")
+		fmt.Fprintf(tabw, "  --ask <category>=<yes|no>,...:\tAnswer questions in the given categories: %v, %v, %v, %v, or %v.\n", AskInstall, AskEdit, AskCached, AskResume, AskClean)
+		tabw.Flush()
 	}
 }
 
@@ -131,6 +163,14 @@ var (
 	PrintUsageError = &UsageError{""}
 )
 
+// ExitStatus is returned by a command to cause pacgo to exit with
+// the given status without printing an error message.
+type ExitStatus int
+
+func (err ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %v", int(err))
+}
+
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -160,6 +200,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	err := LoadConfig()
+	if err != nil {
+		Cprintf("[c7]error:[ce] %v\n", err)
+		os.Exit(1)
+	}
+
+	args, err := ParseGlobalFlags(os.Args[1:])
+	if err != nil {
+		Cprintf("[c7]error:[ce] %v\n", err)
+		Usage("")
+		os.Exit(2)
+	}
+	os.Args = append(os.Args[:1], args...)
+
+	if PacmanConfPath != DefaultPacmanConfPath {
+		SetupColor()
+	}
+
 	if len(os.Args) == 1 {
 		Usage("")
 		os.Exit(2)
@@ -176,12 +234,23 @@ func main() {
 	}
 
 	TmpDir = filepath.Join(os.TempDir(), fmt.Sprintf("%v-%v", filepath.Base(os.Args[0]), os.Getuid()))
-	err := os.MkdirAll(TmpDir, 0755)
+	err = os.MkdirAll(TmpDir, 0755)
 	if err != nil {
 		Cprintf("[c7]error:[ce] Failed to create %v.", TmpDir)
 		os.Exit(1)
 	}
 
+	CacheDir = findCacheDir()
+	err = os.MkdirAll(CacheDir, 0755)
+	if err != nil {
+		Cprintf("[c7]error:[ce] Failed to create %v.", CacheDir)
+		os.Exit(1)
+	}
+
+	if BuildDir == "" {
+		BuildDir = filepath.Join(CacheDir, "build")
+	}
+
 	sig := make(chan os.Signal)
 	signal.Notify(sig, os.Interrupt)
 
@@ -190,6 +259,11 @@ func main() {
 		if cmd := GetCmd(os.Args[1]); cmd != nil {
 			err := cmd.Run(os.Args[1:]...)
 			if err != nil {
+				if es, ok := err.(ExitStatus); ok {
+					done <- int(es)
+					return
+				}
+
 				if ue, ok := err.(*UsageError); ok {
 					if ue != PrintUsageError {
 						Cprintf("[c5]%v: [c7]error:[ce] %v\n", os.Args[1], err)
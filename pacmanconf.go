@@ -0,0 +1,283 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultPacmanConfPath is the location of pacman.conf if no other is
+// given with --config.
+const DefaultPacmanConfPath = "/etc/pacman.conf"
+
+var (
+	// The pacman.conf that is read by pacgo and passed to pacman. It
+	// can be set with the --config flag.
+	PacmanConfPath = DefaultPacmanConfPath
+)
+
+// The maximum depth of nested Include directives. This is the same
+// limit that pacman uses.
+const maxIncludeDepth = 10
+
+// These options may be given more than once, and each value may
+// contain several space separated items.
+var pacmanConfLists = map[string]bool{
+	"Architecture": true,
+	"CacheDir":     true,
+	"HoldPkg":      true,
+	"HookDir":      true,
+	"IgnoreGroup":  true,
+	"IgnorePkg":    true,
+	"NoExtract":    true,
+	"NoUpgrade":    true,
+	"Server":       true,
+}
+
+// PacmanRepo represents a repo section in pacman.conf.
+type PacmanRepo struct {
+	Name    string
+	Servers []string
+
+	// Every option set in the repo's section, including Server.
+	Options map[string][]string
+}
+
+// PacmanConf represents a parsed pacman.conf.
+type PacmanConf struct {
+	RootDir      string
+	DBPath       string
+	CacheDir     []string
+	Architecture []string
+	IgnorePkg    []string
+	IgnoreGroup  []string
+	Color        bool
+
+	// Every option set in the [options] section. Options without a
+	// value, such as Color, map to an empty slice.
+	Options map[string][]string
+
+	// The repos, in the order that they were listed.
+	Repos []PacmanRepo
+}
+
+// ParsePacmanConf parses the pacman.conf at the given path, following
+// any Include directives. It returns the config and nil, or nil and
+// an error, if any.
+func ParsePacmanConf(path string) (*PacmanConf, error) {
+	conf := &PacmanConf{
+		Options: make(map[string][]string),
+	}
+
+	var section string
+	err := conf.parse(path, &section, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.RootDir = conf.Option("RootDir")
+	if conf.RootDir == "" {
+		conf.RootDir = "/"
+	}
+	conf.DBPath = conf.Option("DBPath")
+	if conf.DBPath == "" {
+		conf.DBPath = DefaultDBPath
+	}
+	conf.CacheDir = conf.Options["CacheDir"]
+	if len(conf.CacheDir) == 0 {
+		conf.CacheDir = []string{"/var/cache/pacman/pkg/"}
+	}
+	for _, arch := range conf.Options["Architecture"] {
+		if arch == "auto" {
+			arch = CARCH()
+		}
+		conf.Architecture = append(conf.Architecture, arch)
+	}
+	if len(conf.Architecture) == 0 {
+		conf.Architecture = []string{CARCH()}
+	}
+	conf.IgnorePkg = conf.Options["IgnorePkg"]
+	conf.IgnoreGroup = conf.Options["IgnoreGroup"]
+	_, conf.Color = conf.Options["Color"]
+
+	return conf, nil
+}
+
+// parse parses the file at the given path into conf. section is the
+// name of the current section, which is carried across Include
+// directives. It returns an error, if any.
+func (conf *PacmanConf) parse(path string, section *string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%v: Too many nested includes.", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lines, err := ReadLines(file, false)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range lines {
+		if c := bytes.IndexByte(line, '#'); c >= 0 {
+			line = line[:c]
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if (line[0] == '[') && (line[len(line)-1] == ']') {
+			*section = string(line[1 : len(line)-1])
+			if *section == "" {
+				return fmt.Errorf("%v:%v: Empty section name.", path, i+1)
+			}
+			if *section != "options" {
+				conf.Repos = append(conf.Repos, PacmanRepo{
+					Name:    *section,
+					Options: make(map[string][]string),
+				})
+			}
+			continue
+		}
+
+		if *section == "" {
+			return fmt.Errorf("%v:%v: All directives must belong to a section.", path, i+1)
+		}
+
+		var key, val string
+		if parts := bytes.SplitN(line, []byte{'='}, 2); len(parts) == 2 {
+			key = string(bytes.TrimSpace(parts[0]))
+			val = string(bytes.TrimSpace(parts[1]))
+		} else {
+			key = string(line)
+		}
+
+		if key == "Include" {
+			if val == "" {
+				return fmt.Errorf("%v:%v: Include requires a value.", path, i+1)
+			}
+
+			files, err := filepath.Glob(val)
+			if err != nil {
+				return fmt.Errorf("%v:%v: %v", path, i+1, err)
+			}
+			for _, inc := range files {
+				err := conf.parse(inc, section, depth+1)
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		opts := conf.Options
+		if *section != "options" {
+			opts = conf.Repos[len(conf.Repos)-1].Options
+		}
+
+		vals := []string{val}
+		if pacmanConfLists[key] {
+			vals = strings.Fields(val)
+		} else if val == "" {
+			vals = nil
+		}
+		opts[key] = append(opts[key], vals...)
+
+		if (key == "Server") && (*section != "options") {
+			repo := &conf.Repos[len(conf.Repos)-1]
+			repo.Servers = append(repo.Servers, vals...)
+		}
+	}
+
+	return nil
+}
+
+// Option returns the first value of the named option in the
+// [options] section, or "" if it isn't set.
+func (conf *PacmanConf) Option(name string) string {
+	if vals := conf.Options[name]; len(vals) > 0 {
+		return vals[0]
+	}
+
+	return ""
+}
+
+// Ignored returns true if the named package, which belongs to the
+// given groups, matches any of the IgnorePkg or IgnoreGroup patterns.
+func (conf *PacmanConf) Ignored(name string, groups []string) bool {
+	for _, pat := range conf.IgnorePkg {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+
+	for _, pat := range conf.IgnoreGroup {
+		for _, group := range groups {
+			if ok, _ := path.Match(pat, group); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RepoNames returns the names of the repos, in the order that they
+// were listed.
+func (conf *PacmanConf) RepoNames() []string {
+	names := make([]string, 0, len(conf.Repos))
+	for _, repo := range conf.Repos {
+		names = append(names, repo.Name)
+	}
+
+	return names
+}
+
+var pacmanConf struct {
+	sync.Mutex
+	path string
+	conf *PacmanConf
+	err  error
+}
+
+// PacmanConfig returns the parsed pacman.conf at PacmanConfPath. It's
+// only parsed once for each path. It returns the config and nil, or
+// nil and an error, if any.
+func PacmanConfig() (*PacmanConf, error) {
+	pacmanConf.Lock()
+	defer pacmanConf.Unlock()
+
+	if (pacmanConf.path != PacmanConfPath) || ((pacmanConf.conf == nil) && (pacmanConf.err == nil)) {
+		pacmanConf.path = PacmanConfPath
+		pacmanConf.conf, pacmanConf.err = ParsePacmanConf(PacmanConfPath)
+	}
+
+	return pacmanConf.conf, pacmanConf.err
+}
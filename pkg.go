@@ -21,7 +21,6 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -45,20 +44,12 @@ var (
 // returns false, nil. If any errors occur it returns false and an
 // error.
 func Newer(ver1, ver2 string) (bool, error) {
-	out, err := VercmpOutput(ver1, ver2)
+	c, err := Vercmp(ver1, ver2)
 	if err != nil {
 		return false, err
 	}
-	out = bytes.TrimSpace(out)
 
-	switch string(out) {
-	case "-1", "0":
-		return false, nil
-	case "1":
-		return true, nil
-	}
-
-	panic("Bad vercmp output: " + string(out))
+	return c > 0, nil
 }
 
 // SamePkg returns true if the packages are the same.
@@ -82,13 +73,7 @@ func SamePkg(p1, p2 Pkg) bool {
 
 // cleanName strips version information from the name of a package.
 func cleanName(name string) string {
-	for i := range name {
-		if name[i] == '>' {
-			return name[:i]
-		}
-	}
-
-	return name
+	return ParseDep(name).Name
 }
 
 // Pkg represents a pacman package. This doesn't necessarily have to
@@ -143,25 +128,214 @@ func (err *PkgNotFoundError) Error() string {
 	return "Package not found: " + err.PkgName
 }
 
-// NewRemotePkg returns a new Pkg representing the named package. It
+// NewRemotePkg returns a new Pkg representing the named package. The
+// name may include a version constraint, such as 'foo>=1.2'. It
 // checks the local sync database first, and returns a *PacmanPkg and
-// nil if it finds anything. If it doesn't, it tries the AUR. If it
-// finds the package, it returns a *AURPkg and nil. Otherwise it
-// returns nil and an error. If it is unable to find the package, it
-// returns nil and a PkgNotFoundError.
+// nil if it finds anything that satisfies the constraint. If it
+// doesn't, it tries the AUR. If it finds the package, it returns a
+// *AURPkg and nil. Otherwise it returns nil and an error. If it is
+// unable to find the package, it returns nil and a PkgNotFoundError.
+// If it finds the package, but not in a version that satisfies the
+// constraint, it returns nil and an *UnsatisfiedDepError.
 func NewRemotePkg(name string) (Pkg, error) {
-	if InPacman(name) {
-		return NewPacmanPkg(name)
+	dep := ParseDep(name)
+
+	var unsat error
+	if InPacman(dep.Name) {
+		pkg, err := NewPacmanPkg(dep.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := dep.SatisfiedByPkg(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return pkg, nil
+		}
+
+		ver, _ := pkg.Version()
+		unsat = &UnsatisfiedDepError{dep, ver}
+	}
+	if info, ok := InAUR(dep.Name); ok {
+		ok, err := dep.SatisfiedBy(info.Version)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return NewAURPkg(info)
+		}
+
+		unsat = &UnsatisfiedDepError{dep, info.Version}
+	}
+
+	pkg, err := FindProvider(dep)
+	if err != nil {
+		if _, ok := err.(*PkgNotFoundError); ok && (unsat != nil) {
+			return nil, unsat
+		}
+		return nil, err
+	}
+
+	return pkg, nil
+}
+
+// resolveDeps returns a PkgList representing the given dependencies.
+// Dependencies that are satisfied by an installed package are
+// returned as *LocalPkgs. The sync databases are checked next, and
+// then anything that wasn't found in either is looked up in the AUR
+// using a single batched query. Finally, packages that provide the
+// remaining dependencies are searched for, which may ask the user to
+// choose between them. Dependencies that can't be found anywhere are
+// ignored. If a package is found, but not in a version
+// that satisfies the dependency, it returns the dependencies that it
+// could resolve and an *UnsatisfiedDepError.
+func resolveDeps(names []string) (PkgList, error) {
+	pl := make(PkgList, 0, len(names))
+	unsat := make(map[string]error)
+	var pll sync.Mutex
+
+	seen := make(map[string]bool, len(names))
+
+	var rest []Dep
+	var wg sync.WaitGroup
+	for _, name := range names {
+		if (name == "None") || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		wg.Add(1)
+		go func(dep Dep) {
+			defer wg.Done()
+
+			if InLocal(dep.Name) {
+				pkg, err := NewLocalPkg(dep.Name)
+				if err == nil {
+					if ok, _ := dep.SatisfiedByPkg(pkg); ok {
+						pll.Lock()
+						pl = append(pl, pkg)
+						pll.Unlock()
+
+						return
+					}
+				}
+			}
+
+			if InPacman(dep.Name) {
+				pkg, err := NewPacmanPkg(dep.Name)
+				if err == nil {
+					ok, _ := dep.SatisfiedByPkg(pkg)
+					if ok {
+						pll.Lock()
+						pl = append(pl, pkg)
+						pll.Unlock()
+
+						return
+					}
+
+					ver, _ := pkg.Version()
+					pll.Lock()
+					unsat[dep.Name] = &UnsatisfiedDepError{dep, ver}
+					pll.Unlock()
+				}
+			}
+
+			pll.Lock()
+			rest = append(rest, dep)
+			pll.Unlock()
+		}(ParseDep(name))
+	}
+
+	wg.Wait()
+
+	if len(rest) == 0 {
+		return pl, firstUnsat(names, unsat)
+	}
+
+	clean := make([]string, 0, len(rest))
+	for _, dep := range rest {
+		clean = append(clean, dep.Name)
+	}
+
+	infos, err := AURInfoMulti(clean)
+	if err != nil {
+		return pl, err
+	}
+
+	var missing []Dep
+	for _, dep := range rest {
+		info, ok := infos[dep.Name]
+		if !ok {
+			missing = append(missing, dep)
+			continue
+		}
+
+		ok, err := dep.SatisfiedBy(info.Version)
+		if err != nil {
+			return pl, err
+		}
+		if !ok {
+			unsat[dep.Name] = &UnsatisfiedDepError{dep, info.Version}
+			missing = append(missing, dep)
+			continue
+		}
+		delete(unsat, dep.Name)
+
+		wg.Add(1)
+		go func(info *AURPackage) {
+			defer wg.Done()
+
+			pkg, err := NewAURPkg(info)
+			if err != nil {
+				return
+			}
+
+			pll.Lock()
+			pl = append(pl, pkg)
+			pll.Unlock()
+		}(info)
+	}
+
+	wg.Wait()
+
+	// This is done one at a time, since it can ask the user questions.
+	for _, dep := range missing {
+		pkg, err := FindProvider(dep)
+		if err != nil {
+			if _, ok := err.(*PkgNotFoundError); ok {
+				continue
+			}
+			return pl, err
+		}
+		delete(unsat, dep.Name)
+
+		pl = append(pl, pkg)
 	}
-	if info, ok := InAUR(name); ok {
-		return NewAURPkg(info)
+
+	return pl, firstUnsat(names, unsat)
+}
+
+// firstUnsat returns the error in unsat for the first of the given
+// dependencies that has one, or nil if none of them do.
+func firstUnsat(names []string, unsat map[string]error) error {
+	for _, name := range names {
+		if err, ok := unsat[cleanName(name)]; ok {
+			return err
+		}
 	}
 
-	return nil, &PkgNotFoundError{name}
+	return nil
 }
 
 // InLocal returns true if the named package is installed.
 func InLocal(name string) bool {
+	if db, err := LocalDatabase(); err == nil {
+		_, ok := db.Get(name)
+		return ok
+	}
+
 	err := SilentPacman("-Q", "--", name)
 	if err != nil {
 		return false
@@ -173,6 +347,11 @@ func InLocal(name string) bool {
 // InPacman returns true if the named package was found in the sync
 // database.
 func InPacman(name string) bool {
+	if db, err := SyncDatabase(); err == nil {
+		_, ok := db.Get(name)
+		return ok
+	}
+
 	err := SilentPacman("-Si", "--", name)
 	if err != nil {
 		return false
@@ -182,51 +361,29 @@ func InPacman(name string) bool {
 }
 
 // InAUR checks for the named package in the AUR. If it finds it, it
-// returns the RPCResult for its query and true, else if return an
-// unspecified RPCResult and false.
-func InAUR(name string) (RPCResult, bool) {
+// returns the *AURPackage for its query and true, else it returns nil
+// and false.
+func InAUR(name string) (*AURPackage, bool) {
 	info, err := AURInfo(name)
 	if err != nil {
-		return info, false
+		return nil, false
 	}
 
 	return info, true
 }
 
-// Provides returns a list of packages that provide the package
-// specified or nil if none are found.
-//
-// TODO: This doesn't work at all. It needs to be completely redone.
-//func Provides(pkg string) PkgList {
-//	out, err := PacmanOutput("-Ssq", "^"+pkg+"$")
-//	if err != nil {
-//		return nil
-//	}
-//	out = bytes.TrimSpace(out)
-//
-//	lines := bytes.Split(out, []byte{'\n'})
-//
-//	pkgs := make(PkgList, 0, len(lines))
-//	for _, line := range lines {
-//		name := string(line)
-//		if InPacman(name) {
-//			pkg, err := NewPacmanPkg(name)
-//			if err == nil {
-//				pkgs = append(pkgs, pkg)
-//			}
-//		}
-//	}
-//
-//	if len(pkgs) == 0 {
-//		return nil
-//	}
-//
-//	return pkgs
-//}
-
 // IsDep checks if the named package is installed as a dependency. It
 // returns the result and nil, or false and an error, if any.
 func IsDep(name string) (bool, error) {
+	if db, err := LocalDatabase(); err == nil {
+		pkg, ok := db.Get(name)
+		if !ok {
+			return false, &PkgNotFoundError{name}
+		}
+
+		return pkg.IsDep(), nil
+	}
+
 	info, err := PacmanOutput("-Qi", name)
 	if err != nil {
 		return false, err
@@ -277,45 +434,53 @@ func IsDep(name string) (bool, error) {
 //	panic("Should never reach this point.")
 //}
 
-// InstallPkgs installs the given pkgs using the given args. It
-// returns an error, if any.
+// InstallPkgs installs the given pkgs using the given args. The full
+// set of packages is worked out, confirmed, and reviewed before
+// anything is installed. It returns an error, if any.
 func InstallPkgs(args []string, pkgs PkgList) error {
 	var pacpkgs []string
-	var other PkgList
+	var roots PkgList
 	for _, pkg := range pkgs {
 		switch p := pkg.(type) {
 		case *PacmanPkg:
 			pacpkgs = append(pacpkgs, p.Name())
+			roots = append(roots, p)
 		case InstallPkg:
-			other = append(other, p)
+			roots = append(roots, p)
 		default:
 			Cprintf("[c6]warning:[ce] Don't know how to install %v. Skipping.\n", pkg.Name())
 		}
 	}
 
-	sortDone := make(chan bool)
-	go func() {
-		sort.Sort(other)
-		sortDone <- true
-	}()
+	plan, err := NewPlan(roots, nil)
+	if err != nil {
+		return err
+	}
 
-	if pacpkgs != nil {
-		err := AsRootPacman(append([]string{"-S"}, append(args, pacpkgs...)...)...)
+	// If it's only repo packages, pacman can handle the confirmation.
+	if len(plan.aurPkgs()) != 0 {
+		answer, err := plan.Confirm()
 		if err != nil {
 			return err
 		}
-	}
+		if !answer {
+			return nil
+		}
 
-	<-sortDone
+		err = plan.Review()
+		if err != nil {
+			return err
+		}
+	}
 
-	for _, pkg := range other {
-		err := pkg.(InstallPkg).Install(nil, args...)
+	if pacpkgs != nil {
+		err := AsRootPacman(append([]string{"-S"}, append(args, pacpkgs...)...)...)
 		if err != nil {
-			Cprintf("[c6]warning:[ce] Installation of %v failed (%v). Skipping.\n", pkg.Name(), err)
+			return err
 		}
 	}
 
-	return nil
+	return plan.Run(args, true)
 }
 
 // InfoPkgs prints the info for the given pkgs, using the given args.
@@ -354,6 +519,15 @@ func (p *PacmanPkg) Name() string {
 }
 
 func (p *PacmanPkg) Version() (string, error) {
+	if db, err := SyncDatabase(); err == nil {
+		pkg, ok := db.Get(p.Name())
+		if !ok {
+			return "", &PkgNotFoundError{p.Name()}
+		}
+
+		return pkg.Version, nil
+	}
+
 	info, err := PacmanOutput("-Si", p.Name())
 	if err != nil {
 		return "", err
@@ -386,11 +560,6 @@ func (p *PacmanPkg) Deps() (pl PkgList) {
 		return p.deps
 	}
 
-	lines, err := PacmanLines(true, "-Si", "--", p.Name())
-	if err != nil {
-		return nil
-	}
-
 	defer func() {
 		if len(pl) == 0 {
 			pl = nil
@@ -400,41 +569,32 @@ func (p *PacmanPkg) Deps() (pl PkgList) {
 		p.gotDeps = true
 	}()
 
+	if db, err := SyncDatabase(); err == nil {
+		pkg, ok := db.Get(p.Name())
+		if !ok {
+			return nil
+		}
+
+		pl, _ = resolveDeps(pkg.Depends)
+		return
+	}
+
+	lines, err := PacmanLines(true, "-Si", "--", p.Name())
+	if err != nil {
+		return nil
+	}
+
 	for _, line := range lines {
 		match := DepsRE.FindSubmatch(line)
 		if match != nil {
 			names := bytes.Fields(match[1])
 
-			pl = make(PkgList, 0, len(names))
-			var pll sync.Mutex
-
-			var wg sync.WaitGroup
+			all := make([]string, 0, len(names))
 			for _, name := range names {
-				wg.Add(1)
-				go func(name string) {
-					defer wg.Done()
-
-					pkg, err := NewRemotePkg(name)
-					if err != nil {
-						pkg, err := NewLocalPkg(name)
-						if err != nil {
-							return
-						}
-
-						pll.Lock()
-						pl = append(pl, pkg)
-						pll.Unlock()
-
-						return
-					}
-
-					pll.Lock()
-					pl = append(pl, pkg)
-					pll.Unlock()
-				}(string(name))
+				all = append(all, string(name))
 			}
 
-			wg.Wait()
+			pl, _ = resolveDeps(all)
 
 			return
 		}
@@ -449,39 +609,79 @@ func (p *PacmanPkg) Info(args ...string) error {
 
 // AURPkg represents a package in the AUR.
 type AURPkg struct {
-	info     RPCResult
+	info     *AURPackage
 	pkgbuild *Pkgbuild
+	dir      string
+
+	// Other packages from the same package base that are built and
+	// installed along with this one.
+	extra []*AURPkg
+
+	// Whether or not to install the previously built package files
+	// instead of building them again. This is decided by review().
+	useCached bool
+
+	// Whether or not to resume a build that didn't finish instead of
+	// starting over. This is also decided by review().
+	resume bool
 
 	deps    PkgList
+	depsErr error
 	gotDeps bool
 }
 
-// NewAURPkg returns a *AURPkg using the given info. It returns an
-// the *AURPkg and nil, or nil and an error, if any.
-func NewAURPkg(info RPCResult) (*AURPkg, error) {
-	rsp, err := http.Get(PKGURL(info.GetInfo("Name"), "PKGBUILD"))
+// NewAURPkg returns a *AURPkg using the given info. It fetches the
+// package's build files into CacheDir, unless the ones that are
+// already there are from the same LastModified time. It returns the
+// *AURPkg and nil, or nil and an error, if any.
+func NewAURPkg(info *AURPackage) (*AURPkg, error) {
+	base := info.PackageBase
+	if base == "" {
+		base = info.Name
+	}
+
+	dir, err := AURSrcDir(base)
 	if err != nil {
 		return nil, err
 	}
-	defer rsp.Body.Close()
 
-	pb, err := ParsePkgbuild(rsp.Body)
+	if !srcUpToDate(info) {
+		err = FetchAUR(filepath.Dir(dir), base)
+		if err != nil {
+			return nil, err
+		}
+		markSrcFetched(info)
+	}
+
+	file, err := os.Open(filepath.Join(dir, ".SRCINFO"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pbs, err := ParseSrcinfo(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %v's .SRCINFO: %v", info.Name, err)
+	}
+
+	pb, err := FindPkgbuild(pbs, info.Name)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing %v's PKGBUILD: %v", info.GetInfo("Name"), err)
+		return nil, err
 	}
 
 	return &AURPkg{
 		info:     info,
 		pkgbuild: pb,
+		dir:      dir,
 	}, nil
 }
 
 func (p *AURPkg) Name() string {
-	return p.info.Results.(map[string]interface{})["Name"].(string)
+	return p.info.Name
 }
 
 func (p *AURPkg) Version() (string, error) {
-	return p.info.GetInfo("Version"), nil
+	return p.info.Version, nil
 }
 
 func (p *AURPkg) Deps() (pl PkgList) {
@@ -498,235 +698,169 @@ func (p *AURPkg) Deps() (pl PkgList) {
 		p.gotDeps = true
 	}()
 
-	all := append(p.pkgbuild.Deps, p.pkgbuild.MakeDeps...)
+	all := append(append([]string(nil), p.pkgbuild.Deps...), p.pkgbuild.MakeDeps...)
 
-	pl = make(PkgList, 0, len(all))
-	var pll sync.Mutex
+	pl, p.depsErr = resolveDeps(all)
+	return
+}
 
-	var wg sync.WaitGroup
-	for _, name := range all {
-		wg.Add(1)
-		go func(name string) {
-			defer wg.Done()
+// Base returns the name of the package base that p is built from.
+func (p *AURPkg) Base() string {
+	return p.pkgbuild.Base
+}
 
-			pkg, err := NewRemotePkg(name)
-			if err != nil {
-				pkg, err := NewLocalPkg(name)
-				if err != nil {
-					return
-				}
+// targets returns p and any other packages from the same package base
+// that are being installed along with it.
+func (p *AURPkg) targets() []*AURPkg {
+	return append([]*AURPkg{p}, p.extra...)
+}
 
-				pll.Lock()
-				pl = append(pl, pkg)
-				pll.Unlock()
+// targetNames returns the names of the packages returned by
+// p.targets() as a single string.
+func (p *AURPkg) targetNames() string {
+	names := make([]string, 0, len(p.extra)+1)
+	for _, t := range p.targets() {
+		names = append(names, t.Name())
+	}
 
-				return
-			}
+	return strings.Join(names, " ")
+}
 
-			pll.Lock()
-			pl = append(pl, pkg)
-			pll.Unlock()
-		}(name)
+// reload regenerates the *Pkgbuild for p and for any other packages
+// being installed with it. It returns an error, if any.
+func (p *AURPkg) reload() error {
+	pbs, err := MakepkgSrcinfo(p.dir)
+	if err != nil {
+		return fmt.Errorf("Unable to reload PKGBUILD for %v: %v", p.Name(), err)
 	}
 
-	wg.Wait()
+	for _, t := range p.targets() {
+		t.pkgbuild, err = FindPkgbuild(pbs, t.Name())
+		if err != nil {
+			return err
+		}
+	}
 
-	return
+	return nil
 }
 
-func (p *AURPkg) Install(dep Pkg, args ...string) (err error) {
-	tmp, direrr := MkTmpDir(p.Name())
+// builtPkgs returns the package files for p and any other packages
+// being installed with it. If any of them haven't been built, it
+// returns nil.
+func (p *AURPkg) builtPkgs() []string {
+	dir, err := PkgBuildDir(p.Base())
+	if err != nil {
+		return nil
+	}
+	dir = filepath.Join(dir, buildPackages)
 
-	var isdep bool
-	for _, arg := range args {
-		if arg == "--asdeps" {
-			isdep = true
-			break
+	var files []string
+	for _, t := range p.targets() {
+		file := t.pkgbuild.BuiltPkg(dir)
+		if file == "" {
+			return nil
 		}
+
+		files = append(files, file)
 	}
 
-	genpkg := filepath.Join(tmp,
-		p.Name(),
-		fmt.Sprintf("%v-%v-%v.pkg.tar.xz",
-			p.Name(),
-			p.info.GetInfo("Version"),
-			p.pkgbuild.LocalArch(),
-		),
-	)
+	return files
+}
 
-	var cached bool
-	if _, err := os.Stat(genpkg); !os.IsNotExist(err) {
-		if dep == nil {
-			cached, err = Caskf(true, "[c1]", "[c5]:: [c1]Found cached package for [c5]%v[c1]. Install?[ce]", p.Name())
-			if err != nil {
-				return err
-			}
-		} else {
-			cached, err = Caskf(true, "[c1]", "[c5]:: [c1]Found cached package for [c5]%v[c1]. Install as dependency for [c5]%v[c1]?[ce]", p.Name(), dep.Name())
-			if err != nil {
-				return err
-			}
-		}
+func (p *AURPkg) depsError() error {
+	return p.depsErr
+}
+
+// Install installs p, after installing any AUR packages that it
+// depends on. Everything is confirmed and reviewed before anything is
+// built. See the InstallPkg interface for details.
+func (p *AURPkg) Install(dep Pkg, args ...string) error {
+	plan, err := NewPlan(PkgList{p}, dep)
+	if err != nil {
+		return err
 	}
 
-	if !cached {
-		if direrr != nil {
-			return direrr
-		}
+	answer, err := plan.Confirm()
+	if err != nil {
+		return err
+	}
+	if !answer {
+		return nil
+	}
+
+	err = plan.Review()
+	if err != nil {
+		return err
+	}
 
-		var answer bool
+	return plan.Run(args, false)
+}
+
+// build builds and installs p, along with any packages being
+// installed with it, but not its dependencies. The packages in asdeps
+// are installed as dependencies, and the rest are installed with
+// args. It doesn't ask any questions, so p should be reviewed first.
+// It returns an error, if any.
+func (p *AURPkg) build(dep Pkg, asdeps []*AURPkg, args ...string) error {
+	if !p.useCached {
 		if dep == nil {
-			answer, err = Caskf(true, "[c1]", "[c5]:: [c1]Install [c5]%v[c1]?[ce]", p.Name())
-			if err != nil {
-				return
-			}
+			Cprintf("[c2]==> [c1]Installing [c5]%v [c1]from the [c3]AUR[c1].[ce]\n", p.targetNames())
 		} else {
-			answer, err = Caskf(true, "[c1]", "[c5]:: [c1]Install [c5]%v [c1]as a dependency for [c5]%v[c1]?[ce]",
-				p.Name(),
-				dep.Name(),
-			)
-			if err != nil {
-				return
-			}
-		}
-		if !answer {
-			Cprintf("[c7]Skipping [c5]%v[c7]...[ce]\n\n", p.Name())
-			return nil
+			Cprintf("[c2]==> [c1]Installing [c5]%v [c1]from the [c3]AUR[c1] as a dependency for [c5]%v[c1].[ce]\n", p.targetNames(), dep.Name())
 		}
 
-		Cprintf("[c2]==> [c1]Installing [c5]%v [c1]from the [c3]AUR[c1].[ce]\n", p.Name())
+		// Sources are kept in the build directory between builds, so
+		// -c only removes what was extracted from them.
+		mpargs := []string{"-s", "-c", "-f", "--noconfirm"}
+		if p.resume {
+			mpargs = append(mpargs, "-e")
+		}
 
-		tr, err := GetSourceTar(p.Name())
+		dir, err := PkgBuildDir(p.Base())
 		if err != nil {
 			return err
 		}
 
-		err = ExtractTar(tmp, tr)
+		err = startBuild(p.Base(), p.pkgbuild.VersionString())
 		if err != nil {
 			return err
 		}
 
-		if EditPath != "" {
-			for {
-				answer, err := Caskf(false, "[c1]", "[c5]:: [c1]Edit [c5]PKGBUILD [c1]using [c5]%v?[ce]", filepath.Base(EditPath))
-				if err != nil {
-					return err
-				}
-
-				if answer {
-					pbpath := filepath.Join(tmp, p.Name(), "PKGBUILD")
-					err := Edit(pbpath)
-					if err != nil {
-						return err
-					}
-					file, err := os.Open(pbpath)
-					if err != nil {
-						return fmt.Errorf("Unable to reload PKGBUILD for %v: %v", p.Name(), err)
-					}
-					p.pkgbuild, err = ParsePkgbuild(file)
-					if err != nil {
-						return fmt.Errorf("Unable to reload PKGBUILD for %v: %v", p.Name(), err)
-					}
-					genpkg = filepath.Join(tmp,
-						p.Name(),
-						fmt.Sprintf("%v-%v-%v.pkg.tar.xz",
-							p.Name(),
-							p.pkgbuild.VersionString(),
-							p.pkgbuild.LocalArch(),
-						),
-					)
-				} else {
-					break
-				}
-			}
-
-			if p.pkgbuild.HasInstall() {
-				install := filepath.Join(tmp, p.Name(), p.pkgbuild.Install)
-				if _, err := os.Stat(install); err == nil {
-					for {
-						answer, err := Caskf(false, "[c1]", "[c5]:: [c1]Edit [c5]%v [c1]using [c5]%v?[ce]",
-							p.pkgbuild.Install,
-							filepath.Base(EditPath),
-						)
-						if err != nil {
-							return err
-						}
-
-						if answer {
-							err := Edit(install)
-							if err != nil {
-								return err
-							}
-						} else {
-							break
-						}
-					}
-				} else {
-					Cprintf("[c6]warning:[ce] Can't find %v install script.\n", install)
-				}
-			}
-		}
-
-		if p.pkgbuild.HasDeps() {
-			deps := p.Deps()
-			sort.Sort(deps)
-
-			for _, dep := range deps {
-				if ap, ok := dep.(*AURPkg); ok {
-					if !InLocal(ap.Name()) {
-						err := ap.Install(p, "--asdeps")
-						if err != nil {
-							return err
-						}
-					}
-				}
-			}
+		err = MakepkgEnvIn(p.dir, buildEnv(dir), mpargs...)
+		if err != nil {
+			return err
 		}
-	}
+		finishBuild(p.Base())
 
-	if (dep == nil) && (!isdep) {
-		err = MakepkgIn(filepath.Join(tmp, p.Name()), "-s", "-c", "-i")
+		err = p.reload()
 		if err != nil {
 			return err
 		}
-	} else {
-		if cached {
-			err = AsRootPacman("-U", "--asdeps", genpkg)
-			if err != nil {
-				return err
-			}
-		} else {
-			err = MakepkgIn(filepath.Join(tmp, p.Name()), "-s", "-c")
-			if err != nil {
-				return err
-			}
+	}
 
-			file, err := os.Open(filepath.Join(tmp, p.Name(), "PKGBUILD"))
-			if err != nil {
-				return fmt.Errorf("Unable to reload PKGBUILD for %v: %v", p.Name(), err)
-			}
-			p.pkgbuild, err = ParsePkgbuild(file)
-			if err != nil {
-				return fmt.Errorf("Unable to reload PKGBUILD for %v: %v", p.Name(), err)
-			}
+	files := p.builtPkgs()
+	if files == nil {
+		return fmt.Errorf("Can't find the package files built for %v.", p.targetNames())
+	}
 
-			genpkg = filepath.Join(tmp,
-				p.Name(),
-				fmt.Sprintf("%v-%v-%v.pkg.tar.xz",
-					p.Name(),
-					p.pkgbuild.VersionString(),
-					p.pkgbuild.LocalArch(),
-				),
-			)
+	pacargs := append([]string{"-U", "--noconfirm"}, args...)
+	if len(asdeps) == len(p.targets()) {
+		return AsRootPacman(append(append(pacargs, "--asdeps"), files...)...)
+	}
 
-			err = AsRootPacman("-U", "--asdeps", genpkg)
-			if err != nil {
-				return err
-			}
-		}
+	err := AsRootPacman(append(pacargs, files...)...)
+	if (err != nil) || (len(asdeps) == 0) {
+		return err
 	}
 
-	return nil
+	// Some of the packages were explicitly requested, so the others
+	// have to be marked as dependencies separately.
+	names := make([]string, 0, len(asdeps))
+	for _, t := range asdeps {
+		names = append(names, t.Name())
+	}
+
+	return AsRootPacman(append([]string{"-D", "--asdeps"}, names...)...)
 }
 
 func (p *AURPkg) Info(args ...string) error {
@@ -736,10 +870,11 @@ func (p *AURPkg) Info(args ...string) error {
 	}
 
 	Cprintf("[c1]Repository     : [c3]aur[ce]\n")
-	Cprintf("[c1]Name           : %v[ce]\n", p.info.GetInfo("Name"))
-	Cprintf("[c1]Version        : [c2]%v[ce]\n", p.info.GetInfo("Version"))
-	Cprintf("[c1]URL            : [c4]%v[ce]\n", p.info.GetInfo("URL"))
-	Cprintf("[c1]Licenses       :[ce] %v\n", p.info.GetInfo("License"))
+	Cprintf("[c1]Name           : %v[ce]\n", p.info.Name)
+	Cprintf("[c1]Package Base   :[ce] %v\n", p.Base())
+	Cprintf("[c1]Version        : [c2]%v[ce]\n", p.info.Version)
+	Cprintf("[c1]URL            : [c4]%v[ce]\n", p.info.URL)
+	Cprintf("[c1]Licenses       :[ce] %v\n", strings.Join(p.info.License, " "))
 	Cprintf("[c1]Groups         :[ce] %v\n",
 		strings.TrimSpace(strings.Join(p.pkgbuild.Groups, " ")),
 	)
@@ -768,7 +903,11 @@ func (p *AURPkg) Info(args ...string) error {
 		strings.TrimSpace(strings.Join(p.pkgbuild.Arch, " ")),
 	)
 	Cprintf("[c1]Install Script :[ce] %v\n", installscript)
-	Cprintf("[c1]Description    :[ce] %v\n", p.info.GetInfo("Description"))
+	Cprintf("[c1]Maintainer     :[ce] %v\n", p.info.MaintainerString())
+	Cprintf("[c1]Votes          :[ce] %v\n", p.info.NumVotes)
+	Cprintf("[c1]Popularity     :[ce] %.2f\n", p.info.Popularity)
+	Cprintf("[c1]Out Of Date    :[ce] %v\n", p.info.OutOfDateString())
+	Cprintf("[c1]Description    :[ce] %v\n", p.info.Description)
 	fmt.Println()
 
 	return nil
@@ -801,6 +940,19 @@ func NewLocalPkg(name string) (*LocalPkg, error) {
 // ListForeignPkgs returns either a slice containing the names of all
 // installed foreign packages and nil, or nil and an error, if any.
 func ListForeignPkgs() ([]string, error) {
+	if db, err := LocalDatabase(); err == nil {
+		if sdb, err := SyncDatabase(); err == nil {
+			var list []string
+			for _, name := range db.Names() {
+				if _, ok := sdb.Get(name); !ok {
+					list = append(list, name)
+				}
+			}
+
+			return list, nil
+		}
+	}
+
 	lines, err := PacmanLines(true, "-Qqm")
 	if err != nil {
 		return nil, err
@@ -820,11 +972,121 @@ func ListForeignPkgs() ([]string, error) {
 	return list, nil
 }
 
+// AURUpdate describes an installed foreign package that was found in
+// the AUR.
+type AURUpdate struct {
+	Info *AURPackage
+
+	// The version that's installed.
+	Installed string
+
+	// Newer is true if the version in the AUR is newer than the one
+	// that's installed.
+	Newer bool
+}
+
+// Groups returns the groups that the package belongs to, either in
+// the AUR or as it's installed.
+func (up *AURUpdate) Groups() []string {
+	groups := up.Info.Groups
+	if db, err := LocalDatabase(); err == nil {
+		if pkg, ok := db.Get(up.Info.Name); ok {
+			groups = append(append([]string(nil), groups...), pkg.Groups...)
+		}
+	}
+
+	return groups
+}
+
+// CheckAURUpdates looks up installed foreign packages in the AUR and
+// compares the versions. If no names are given, every foreign package
+// is checked, and packages that aren't in the AUR are skipped. If
+// names are given, only those packages are checked, and it's an error
+// if any of them aren't installed, aren't foreign, or aren't in the
+// AUR. It returns the packages, sorted by name, and nil, or nil and
+// an error, if any.
+func CheckAURUpdates(names ...string) ([]AURUpdate, error) {
+	fpkgs, err := ListForeignPkgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) != 0 {
+		foreign := make(map[string]bool, len(fpkgs))
+		for _, name := range fpkgs {
+			foreign[name] = true
+		}
+
+		seen := make(map[string]bool, len(names))
+		fpkgs = make([]string, 0, len(names))
+		for _, name := range names {
+			if !foreign[name] {
+				if !InLocal(name) {
+					return nil, fmt.Errorf("%v is not installed.", name)
+				}
+				return nil, fmt.Errorf("%v is not a foreign package. Use pacman to upgrade it.", name)
+			}
+
+			if !seen[name] {
+				fpkgs = append(fpkgs, name)
+				seen[name] = true
+			}
+		}
+	}
+	sort.Strings(fpkgs)
+
+	infos, err := AURInfoMulti(fpkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	ups := make([]AURUpdate, 0, len(infos))
+	for _, name := range fpkgs {
+		info, ok := infos[name]
+		if !ok {
+			if len(names) != 0 {
+				return nil, fmt.Errorf("%v was not found in the AUR.", name)
+			}
+			continue
+		}
+
+		lpkg, err := NewLocalPkg(name)
+		if err != nil {
+			return nil, err
+		}
+		lver, err := lpkg.Version()
+		if err != nil {
+			return nil, err
+		}
+		newer, err := Newer(info.Version, lver)
+		if err != nil {
+			return nil, err
+		}
+
+		ups = append(ups, AURUpdate{
+			Info:      info,
+			Installed: lver,
+			Newer:     newer,
+		})
+	}
+
+	return ups, nil
+}
+
 func (p *LocalPkg) Name() string {
 	return p.name
 }
 
 func (p *LocalPkg) Version() (string, error) {
+	if db, err := LocalDatabase(); err == nil {
+		pkg, ok := db.Get(p.Name())
+		if !ok {
+			return "", &PkgNotFoundError{p.Name()}
+		}
+
+		return pkg.Version, nil
+	}
+
 	info, err := PacmanOutput("-Qi", p.Name())
 	if err != nil {
 		return "", err
@@ -843,11 +1105,6 @@ func (p *LocalPkg) Deps() (pl PkgList) {
 		return p.deps
 	}
 
-	lines, err := PacmanLines(true, "-Qi", "--", p.Name())
-	if err != nil {
-		return nil
-	}
-
 	defer func() {
 		if len(pl) == 0 {
 			pl = nil
@@ -857,41 +1114,32 @@ func (p *LocalPkg) Deps() (pl PkgList) {
 		p.gotDeps = true
 	}()
 
+	if db, err := LocalDatabase(); err == nil {
+		pkg, ok := db.Get(p.Name())
+		if !ok {
+			return nil
+		}
+
+		pl, _ = resolveDeps(pkg.Depends)
+		return
+	}
+
+	lines, err := PacmanLines(true, "-Qi", "--", p.Name())
+	if err != nil {
+		return nil
+	}
+
 	for _, line := range lines {
 		match := DepsRE.FindSubmatch(line)
 		if match != nil {
 			names := bytes.Fields(match[1])
 
-			pl = make(PkgList, 0, len(names))
-			var pll sync.Mutex
-
-			var wg sync.WaitGroup
+			all := make([]string, 0, len(names))
 			for _, name := range names {
-				wg.Add(1)
-				go func(name string) {
-					defer wg.Done()
-
-					pkg, err := NewRemotePkg(name)
-					if err != nil {
-						pkg, err := NewLocalPkg(name)
-						if err != nil {
-							return
-						}
-
-						pll.Lock()
-						pl = append(pl, pkg)
-						pll.Unlock()
-
-						return
-					}
-
-					pll.Lock()
-					pl = append(pl, pkg)
-					pll.Unlock()
-				}(string(name))
+				all = append(all, string(name))
 			}
 
-			wg.Wait()
+			pl, _ = resolveDeps(all)
 
 			return
 		}
@@ -912,16 +1160,21 @@ func (p *LocalPkg) Info(args ...string) error {
 // PkgbuildPkg represents a package that hasn't been built yet.
 type PkgbuildPkg struct {
 	pkgbuild *Pkgbuild
+	split    []*Pkgbuild
 
 	deps    PkgList
+	depsErr error
 	gotDeps bool
 }
 
 // NewPkgbuildPkg returns a *PkgbuildPkg representing the given
-// PKGBUILD.
-func NewPkgbuildPkg(pb *Pkgbuild) (*PkgbuildPkg, error) {
+// PKGBUILD. Any other packages built along with it, in the case of a
+// split package, can be given as well, in which case their
+// dependencies are included in the *PkgbuildPkg's.
+func NewPkgbuildPkg(pb *Pkgbuild, split ...*Pkgbuild) (*PkgbuildPkg, error) {
 	return &PkgbuildPkg{
 		pkgbuild: pb,
+		split:    split,
 	}, nil
 }
 
@@ -952,40 +1205,44 @@ func (p *PkgbuildPkg) Deps() (pl PkgList) {
 		p.gotDeps = true
 	}()
 
-	all := append(p.pkgbuild.Deps, p.pkgbuild.MakeDeps...)
-
-	pl = make(PkgList, 0, len(all))
-	var pll sync.Mutex
+	all := append(append([]string(nil), p.pkgbuild.Deps...), p.pkgbuild.MakeDeps...)
+	for _, pb := range p.split {
+		all = append(all, pb.Deps...)
+	}
 
-	var wg sync.WaitGroup
-	for _, name := range all {
-		wg.Add(1)
-		go func(name string) {
-			defer wg.Done()
+	// Packages in the same PKGBUILD can depend on each other.
+	internal := make(map[string]bool, len(p.pkgbuild.Names))
+	for _, name := range p.pkgbuild.Names {
+		internal[name] = true
+	}
 
-			pkg, err := NewRemotePkg(name)
-			if err != nil {
-				pkg, err := NewLocalPkg(name)
-				if err != nil {
-					return
-				}
+	deps := make([]string, 0, len(all))
+	for _, dep := range all {
+		if !internal[cleanName(dep)] {
+			deps = append(deps, dep)
+		}
+	}
 
-				pll.Lock()
-				pl = append(pl, pkg)
-				pll.Unlock()
+	pl, p.depsErr = resolveDeps(deps)
+	return
+}
 
-				return
-			}
+func (p *PkgbuildPkg) depsError() error {
+	return p.depsErr
+}
 
-			pll.Lock()
-			pl = append(pl, pkg)
-			pll.Unlock()
-		}(name)
+// hasDeps returns true if any of the packages built by p have deps.
+func (p *PkgbuildPkg) hasDeps() bool {
+	if p.pkgbuild.HasDeps() {
+		return true
+	}
+	for _, pb := range p.split {
+		if pb.HasDeps() {
+			return true
+		}
 	}
 
-	wg.Wait()
-
-	return
+	return false
 }
 
 func (p *PkgbuildPkg) Install(dep Pkg, args ...string) error {
@@ -1004,17 +1261,31 @@ argloop:
 	}
 
 	// Just let makepkg fail if dependencies are missing.
-	if depauth && p.pkgbuild.HasDeps() {
-		deps := p.Deps()
-		sort.Sort(deps)
-
-		for _, dep := range deps {
-			if ap, ok := dep.(*AURPkg); ok {
-				err := ap.Install(p, "--asdeps")
-				if err != nil {
-					return err
-				}
-			}
+	if depauth && p.hasDeps() {
+		plan, err := NewPlan(PkgList{p}, nil)
+		if err != nil {
+			return err
+		}
+
+		// makepkg builds p itself once its dependencies are installed.
+		plan.without(p)
+
+		answer, err := plan.Confirm()
+		if err != nil {
+			return err
+		}
+		if !answer {
+			return nil
+		}
+
+		err = plan.Review()
+		if err != nil {
+			return err
+		}
+
+		err = plan.Run(nil, false)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -1033,6 +1304,7 @@ func (p *PkgbuildPkg) Info(args ...string) error {
 	}
 
 	Cprintf("[c1]Name           : %v[ce]\n", p.pkgbuild.Name)
+	Cprintf("[c1]Package Base   :[ce] %v\n", p.pkgbuild.Base)
 	Cprintf("[c1]Version        : [c2]%v[ce]\n", ver)
 	Cprintf("[c1]URL            : [c4]%v[ce]\n", p.pkgbuild.URL)
 	Cprintf("[c1]Licenses       :[ce] %v\n",
@@ -28,11 +28,16 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 )
 
 // A bash script that echos parts of a PKGBUILD in a more parsable
 // format.
 const pkgbuildScan = `echo "name:$pkgname"
+echo "base:${pkgbase:-$pkgname}"
+for ((i=0; i<${#pkgname[*]}; i++)); do
+	echo "pkgname:${pkgname[i]}"
+done
 echo "ver:$pkgver"
 echo "rel:$pkgrel"
 echo "epoch:$epoch"
@@ -88,6 +93,19 @@ for ((i=0; i<${#arch}; i++)); do
 	echo "arch:${arch[i]}"
 done
 
+for ((i=0; i<${#source[*]}; i++)); do
+	echo "source:${source[i]}"
+done
+
+for a in "${arch[@]}"; do
+	for n in depends makedepends checkdepends optdepends provides conflicts replaces source; do
+		eval "vals=(\"\${${n}_${a}[@]}\")"
+		for v in "${vals[@]}"; do
+			echo "archlist:${n}_${a}:$v"
+		done
+	done
+done
+
 echo "install:$install"
 echo "desc:$pkgdesc"
 
@@ -108,30 +126,44 @@ fi
 exit
 `
 
-// Pkgbuild represents a PKGBUILD.
+// Pkgbuild represents a single package built by a PKGBUILD. For
+// split packages, there is one *Pkgbuild for each package.
 type Pkgbuild struct {
-	Name        string
-	Version     string
-	Release     int
-	Epoch       int
-	URL         string
-	Licenses    []string
-	Groups      []string
-	Provides    []string
-	Deps        []string
-	MakeDeps    []string
-	CheckDeps   []string
-	OptDeps     []string
-	Conflicts   []string
-	Replaces    []string
-	Arch        []string
-	Install     string
+	Name      string
+	Base      string
+	Names     []string // All of the packages built with Name.
+	Version   string
+	Release   string
+	Epoch     int
+	URL       string
+	Licenses  []string
+	Groups    []string
+	Provides  []string
+	Deps      []string
+	MakeDeps  []string
+	CheckDeps []string
+	OptDeps   []string
+	Conflicts []string
+	Replaces  []string
+	Arch      []string
+	Sources   []string
+	Install   string
+
+	// Architecture specific lists, such as depends_x86_64, keyed by
+	// their full names. The ones for the local architecture have
+	// already been merged into the main lists.
+	ArchLists map[string][]string
+
 	Description string
 	VCS         string
 }
 
 // ParsePkgbuild parses a PKGBUILD read from r. It returns a *Pkgbuild
 // and nil, or nil and an error, if any.
+//
+// Note that this runs the PKGBUILD using bash, so it should only be
+// used for PKGBUILDs that are trusted. ParseSrcinfo() should be used
+// instead whenever possible.
 func ParsePkgbuild(r io.Reader) (*Pkgbuild, error) {
 	cmd := &exec.Cmd{
 		Path: BashPath,
@@ -202,15 +234,20 @@ func ParsePkgbuild(r io.Reader) (*Pkgbuild, error) {
 		switch string(parts[0]) {
 		case "name":
 			pb.Name = string(bytes.TrimSpace(parts[1]))
+		case "base":
+			pb.Base = string(bytes.TrimSpace(parts[1]))
+		case "pkgname":
+			if str := string(bytes.TrimSpace(parts[1])); str != "" {
+				pb.Names = append(pb.Names, str)
+			}
 		case "ver":
 			pb.Version = string(bytes.TrimSpace(parts[1]))
 		case "rel":
-			rel, err := strconv.ParseInt(string(parts[1]), 10, 0)
-			if err != nil {
-				ne := err.(*strconv.NumError)
-				return nil, fmt.Errorf("Got bad $pkgrel: %v.", ne.Num)
+			rel := string(bytes.TrimSpace(parts[1]))
+			if !validRelease(rel) {
+				return nil, fmt.Errorf("Got bad $pkgrel: %v.", rel)
 			}
-			pb.Release = int(rel)
+			pb.Release = rel
 		case "epoch":
 			if str := string(bytes.TrimSpace(parts[1])); str == "" {
 				pb.Epoch = 0
@@ -334,6 +371,22 @@ func ParsePkgbuild(r io.Reader) (*Pkgbuild, error) {
 			if str := string(bytes.TrimSpace(parts[1])); str != "" {
 				pb.Arch = append(pb.Arch, str)
 			}
+		case "source":
+			if str := string(bytes.TrimSpace(parts[1])); str != "" {
+				pb.Sources = append(pb.Sources, str)
+			}
+		case "archlist":
+			al := bytes.SplitN(parts[1], []byte{':'}, 2)
+			if len(al) != 2 {
+				return nil, fmt.Errorf("Got bad archlist: %s.", parts[1])
+			}
+			if pb.ArchLists == nil {
+				pb.ArchLists = make(map[string][]string)
+			}
+			key := string(al[0])
+			if str := string(bytes.TrimSpace(al[1])); str != "" {
+				pb.ArchLists[key] = append(pb.ArchLists[key], str)
+			}
 		case "install":
 			pb.Install = string(bytes.TrimSpace(parts[1]))
 		case "desc":
@@ -343,6 +396,78 @@ func ParsePkgbuild(r io.Reader) (*Pkgbuild, error) {
 		}
 	}
 
+	pb.mergeArch()
+
+	err = pb.fillDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return pb, nil
+}
+
+// archListNames are the names of the lists that can have
+// architecture specific versions.
+var archListNames = []string{
+	"depends",
+	"makedepends",
+	"checkdepends",
+	"optdepends",
+	"provides",
+	"conflicts",
+	"replaces",
+	"source",
+}
+
+// list returns a pointer to the list in the *Pkgbuild with the given
+// PKGBUILD name, or nil if there isn't one.
+func (pb *Pkgbuild) list(name string) *[]string {
+	switch name {
+	case "license":
+		return &pb.Licenses
+	case "groups":
+		return &pb.Groups
+	case "provides":
+		return &pb.Provides
+	case "depends":
+		return &pb.Deps
+	case "makedepends":
+		return &pb.MakeDeps
+	case "checkdepends":
+		return &pb.CheckDeps
+	case "optdepends":
+		return &pb.OptDeps
+	case "conflicts":
+		return &pb.Conflicts
+	case "replaces":
+		return &pb.Replaces
+	case "arch":
+		return &pb.Arch
+	case "source":
+		return &pb.Sources
+	}
+
+	return nil
+}
+
+// mergeArch appends the architecture specific lists for CARCH() to
+// the main lists.
+func (pb *Pkgbuild) mergeArch() {
+	carch := CARCH()
+	for _, name := range archListNames {
+		if vals, ok := pb.ArchLists[name+"_"+carch]; ok {
+			// Split packages can share lists, so don't append in
+			// place.
+			list := pb.list(name)
+			*list = append(append([]string(nil), *list...), vals...)
+		}
+	}
+}
+
+// fillDefaults fills in "None" for any empty lists in the *Pkgbuild,
+// and makes sure that the required fields are set. It returns an
+// error, if any.
+func (pb *Pkgbuild) fillDefaults() error {
 	if len(pb.Licenses) == 0 {
 		pb.Licenses = []string{"None"}
 	}
@@ -371,10 +496,10 @@ func ParsePkgbuild(r io.Reader) (*Pkgbuild, error) {
 		pb.Replaces = []string{"None"}
 	}
 	if len(pb.Arch) == 0 {
-		return nil, errors.New("PKGBUILD doesn't have an arch.")
+		return errors.New("PKGBUILD doesn't have an arch.")
 	}
 
-	return pb, nil
+	return nil
 }
 
 // HasDeps returns true if the *Pkgbuild has any deps.
@@ -402,22 +527,39 @@ func (p *Pkgbuild) InstallScript() string {
 	return p.Install
 }
 
-// LocalArch returns the arch string for the *Pkgbuild that a package built on the local machine using the PKGBUILD would be likely to have.
-func (p *Pkgbuild) LocalArch() string {
-	if (len(p.Arch) == 1) && (p.Arch[0] == "any") {
-		return "any"
+// CARCHOverride, if set, is used by CARCH() instead of detecting the
+// local architecture. It can be set using the CARCH option in the
+// config file or the CARCH environment variable.
+var CARCHOverride string
+
+// CARCH returns the architecture of the local machine, in the form
+// used by PKGBUILDs.
+func CARCH() string {
+	if CARCHOverride != "" {
+		return CARCHOverride
 	}
 
-	var find string
 	switch runtime.GOARCH {
 	case "386":
-		find = "i686"
+		return "i686"
 	case "amd64":
-		find = "x86_64"
-	default:
-		return ""
+		return "x86_64"
+	case "arm":
+		return "armv7h"
+	case "arm64":
+		return "aarch64"
 	}
 
+	return runtime.GOARCH
+}
+
+// LocalArch returns the arch string for the *Pkgbuild that a package built on the local machine using the PKGBUILD would be likely to have.
+func (p *Pkgbuild) LocalArch() string {
+	if (len(p.Arch) == 1) && (p.Arch[0] == "any") {
+		return "any"
+	}
+
+	find := CARCH()
 	for _, a := range p.Arch {
 		if a == find {
 			return find
@@ -427,11 +569,49 @@ func (p *Pkgbuild) LocalArch() string {
 	return ""
 }
 
+// CheckPkgName returns an error if name isn't usable as the name of a
+// package or package base. Since these names are used in paths, names
+// that are empty, contain a slash or "..", or start with a dot are
+// rejected.
+func CheckPkgName(name string) error {
+	if (name == "") ||
+		strings.HasPrefix(name, ".") ||
+		strings.Contains(name, "/") ||
+		strings.Contains(name, "..") {
+		return fmt.Errorf("Invalid package name: %q", name)
+	}
+
+	return nil
+}
+
+// validRelease returns true if rel is a valid pkgrel. Like makepkg,
+// it accepts a number, optionally followed by a dot and another
+// number, such as 1 or 1.1.
+func validRelease(rel string) bool {
+	parts := strings.Split(rel, ".")
+	if len(parts) > 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for i := 0; i < len(part); i++ {
+			if !isDigit(part[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // VersionString returns the version that would be part of the
 // filename of a package generated from the PKGBUILD.
 func (p *Pkgbuild) VersionString() string {
 	var epoch string
-	if p.Epoch > 1 {
+	if p.Epoch > 0 {
 		epoch = fmt.Sprintf("%v:", p.Epoch)
 	}
 
@@ -442,6 +622,25 @@ func (p *Pkgbuild) VersionString() string {
 	)
 }
 
+// BuiltPkg returns the path of the package file in dir that was built
+// from the *Pkgbuild, or "" if it hasn't been built.
+func (p *Pkgbuild) BuiltPkg(dir string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir,
+		fmt.Sprintf("%v-%v-%v.pkg.tar*",
+			p.Name,
+			p.VersionString(),
+			p.LocalArch(),
+		),
+	))
+	for _, match := range matches {
+		if !strings.HasSuffix(match, ".sig") {
+			return match
+		}
+	}
+
+	return ""
+}
+
 // IsVCS returns true if p represents a VCS PKGBUILD.
 func (p *Pkgbuild) IsVCS() bool {
 	return len(p.VCS) > 0
@@ -0,0 +1,325 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan is the full set of packages that an installation will touch,
+// worked out before anything is built. This allows everything to be
+// confirmed and reviewed up front so that the builds themselves can
+// run unattended.
+type Plan struct {
+	g *DepGraph
+
+	// The packages in the order that they need to be installed in.
+	// Packages from the same package base are merged into the first
+	// one. See DepGraph.MergeBases().
+	order PkgList
+
+	// The package that the roots are being installed as dependencies
+	// of, or nil.
+	dep Pkg
+}
+
+// NewPlan resolves the transitive dependencies of roots, which are
+// installed as dependencies of dep, if it isn't nil. It returns the
+// plan and nil, or nil and an error, if any.
+func NewPlan(roots PkgList, dep Pkg) (*Plan, error) {
+	g, err := NewDepGraph(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	// Packages from the same package base only need to be built once.
+	g.MergeBases()
+
+	order, err := g.Sort()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plan{
+		g:     g,
+		order: order,
+		dep:   dep,
+	}, nil
+}
+
+// asdeps returns the packages that are installed along with p,
+// including p itself, that should be installed as dependencies. These
+// are the ones that aren't roots, or all of them if the plan is for a
+// dependency.
+func (plan *Plan) asdeps(p *AURPkg) []*AURPkg {
+	var deps []*AURPkg
+	for _, t := range p.targets() {
+		if (plan.dep != nil) || !plan.g.IsRoot(t) {
+			deps = append(deps, t)
+		}
+	}
+
+	return deps
+}
+
+// without removes pkg from the plan, so that it isn't installed by
+// it. This is for packages that are installed some other way once
+// their dependencies have been taken care of.
+func (plan *Plan) without(pkg Pkg) {
+	order := make(PkgList, 0, len(plan.order))
+	for _, p := range plan.order {
+		if p != pkg {
+			order = append(order, p)
+		}
+	}
+
+	plan.order = order
+}
+
+// aurPkgs returns the *AURPkgs that need to be built.
+func (plan *Plan) aurPkgs() []*AURPkg {
+	var pkgs []*AURPkg
+	for _, pkg := range plan.order {
+		if ap, ok := pkg.(*AURPkg); ok {
+			pkgs = append(pkgs, ap)
+		}
+	}
+
+	return pkgs
+}
+
+// repoDeps returns the *PacmanPkgs that are needed by the packages
+// being built.
+func (plan *Plan) repoDeps() PkgList {
+	var pkgs PkgList
+	for _, pkg := range plan.order {
+		if _, ok := pkg.(*PacmanPkg); ok && !plan.g.IsRoot(pkg) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	return pkgs
+}
+
+// Empty returns true if there's nothing for the plan to do.
+func (plan *Plan) Empty() bool {
+	for _, pkg := range plan.order {
+		switch pkg.(type) {
+		case *LocalPkg:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// sizeString formats a size in bytes the way pacman does.
+func sizeString(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	s := float64(size)
+	unit := 0
+	for (s >= 1024) && (unit < len(units)-1) {
+		s /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.2f %v", s, units[unit])
+}
+
+// Summary prints a pacman style summary of the plan, listing the
+// packages from the repos and the AUR separately, along with the
+// sizes of the repo packages where they're known.
+func (plan *Plan) Summary() {
+	var repoTargets, repoDeps, aurTargets, aurDeps, other []string
+	var dlsize, isize int64
+
+	db, dberr := SyncDatabase()
+
+	for _, pkg := range plan.order {
+		var pkgs []Pkg
+		switch p := pkg.(type) {
+		case *AURPkg:
+			for _, t := range p.targets() {
+				pkgs = append(pkgs, t)
+			}
+		case *LocalPkg:
+			continue
+		default:
+			pkgs = []Pkg{p}
+		}
+
+		for _, p := range pkgs {
+			name := p.Name()
+			if ver, err := p.Version(); err == nil {
+				name += "-" + ver
+			}
+
+			root := plan.g.IsRoot(p)
+			switch p.(type) {
+			case *AURPkg:
+				if root {
+					aurTargets = append(aurTargets, name)
+				} else {
+					aurDeps = append(aurDeps, name)
+				}
+			case *PacmanPkg:
+				if root {
+					repoTargets = append(repoTargets, name)
+				} else {
+					repoDeps = append(repoDeps, name)
+				}
+
+				if dberr == nil {
+					if sp, ok := db.Get(p.Name()); ok {
+						dlsize += sp.DownloadSize
+						isize += sp.InstalledSize
+					}
+				}
+			default:
+				other = append(other, name)
+			}
+		}
+	}
+
+	section := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+
+		Cprintf("[c6]%v (%v):[ce] %v\n", title, len(names), strings.Join(names, "  "))
+	}
+
+	fmt.Println()
+	section("Repo Targets", repoTargets)
+	section("Repo Dependencies", repoDeps)
+	section("AUR Dependencies", aurDeps)
+	section("AUR Targets", aurTargets)
+	section("Targets", other)
+
+	if (dlsize != 0) || (isize != 0) {
+		fmt.Println()
+		Cprintf("[c1]Total Download Size:[ce]    %v\n", sizeString(dlsize))
+		Cprintf("[c1]Total Installed Size:[ce]   %v\n", sizeString(isize))
+	}
+	fmt.Println()
+}
+
+// Confirm prints the plan's summary and asks whether or not to go
+// ahead with it. If there's nothing to do, it returns true without
+// asking. It returns the answer and an error, if any.
+func (plan *Plan) Confirm() (bool, error) {
+	if plan.Empty() {
+		return true, nil
+	}
+
+	plan.Summary()
+
+	return Caskf(AskInstall, true, "[c1]", "[c5]:: [c1]Proceed with installation?[ce]")
+}
+
+// Review goes through every AUR package in the plan, asking whether
+// to use cached packages and offering to edit the build files, so
+// that nothing needs to be asked once the builds start. It returns an
+// error, if any.
+func (plan *Plan) Review() error {
+	for _, ap := range plan.aurPkgs() {
+		err := ap.review()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run installs the plan's repo dependencies, and then builds and
+// installs its AUR packages in order. Other installable root packages
+// are installed with args. Repo packages that are roots are left to
+// the caller. If a package fails to install, any packages that depend
+// on it are skipped. If keepGoing is true, a warning is printed for
+// each failure and it carries on with the rest. Otherwise, it stops
+// at the first failure. It returns the first error, if any.
+func (plan *Plan) Run(args []string, keepGoing bool) error {
+	if deps := plan.repoDeps(); len(deps) != 0 {
+		names := make([]string, 0, len(deps))
+		for _, pkg := range deps {
+			names = append(names, pkg.Name())
+		}
+
+		// The user already agreed to install these.
+		err := AsRootPacman(append([]string{"-S", "--needed", "--asdeps", "--noconfirm"}, names...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := make(map[string]bool)
+	var firstErr error
+	for _, pkg := range plan.order {
+		var err error
+		for _, d := range plan.g.DepsOf(pkg) {
+			if failed[depKey(d)] {
+				err = fmt.Errorf("Dependency %v failed to install.", d.Name())
+				break
+			}
+		}
+
+		if err == nil {
+			switch p := pkg.(type) {
+			case *AURPkg:
+				asdeps := plan.asdeps(p)
+				if plan.g.IsRoot(p) || (len(asdeps) < len(p.targets())) {
+					err = p.build(plan.dep, asdeps, args...)
+				} else {
+					err = p.build(plan.g.RequiredBy(p), asdeps)
+				}
+			case *PacmanPkg:
+				continue
+			case InstallPkg:
+				if !plan.g.IsRoot(p) {
+					continue
+				}
+				err = p.Install(plan.dep, args...)
+			default:
+				continue
+			}
+		}
+
+		if err != nil {
+			failed[depKey(pkg)] = true
+			if ap, ok := pkg.(*AURPkg); ok {
+				for _, t := range ap.extra {
+					failed[depKey(t)] = true
+				}
+			}
+
+			if !keepGoing {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			Cprintf("[c6]warning:[ce] Installation of %v failed (%v). Skipping.\n", pkg.Name(), err)
+		}
+	}
+
+	return firstErr
+}
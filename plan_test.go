@@ -0,0 +1,45 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanAsdeps(t *testing.T) {
+	first := testAURPkg("split-a", "split")
+	second := testAURPkg("split-b", "split")
+	third := testAURPkg("split-c", "split")
+	root := testAURPkg("root", "root")
+
+	g := testDepGraph(PkgList{root, second}, map[Pkg]PkgList{
+		root: {first, third},
+	})
+	g.MergeBases()
+
+	plan := &Plan{g: g}
+	deps := plan.asdeps(second)
+	if (len(deps) != 2) || (deps[0] != first) || (deps[1] != third) {
+		t.Errorf("Got %v", deps)
+	}
+
+	plan.dep = root
+	if deps := plan.asdeps(second); len(deps) != 3 {
+		t.Errorf("Got %v for a plan for a dependency", deps)
+	}
+}
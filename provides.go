@@ -0,0 +1,317 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Provider represents a package that can satisfy a dependency,
+// either because it has the right name or because it provides it.
+type Provider struct {
+	Name     string
+	Version  string
+	Repo     string // "local" for installed packages, "aur" for the AUR.
+	Provides []string
+
+	info *AURPackage
+}
+
+// Satisfies returns true if the provider satisfies dep, either by
+// name or through its provides list.
+func (p *Provider) Satisfies(dep Dep) bool {
+	if p.Name == dep.Name {
+		ok, _ := dep.SatisfiedBy(p.Version)
+		return ok
+	}
+
+	for _, prov := range p.Provides {
+		pdep := ParseDep(prov)
+		if pdep.Name != dep.Name {
+			continue
+		}
+
+		// Unversioned provides only satisfy unversioned deps.
+		if dep.Op == "" {
+			return true
+		}
+		if pdep.Op != "=" {
+			continue
+		}
+
+		if ok, _ := dep.SatisfiedBy(pdep.Version); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Pkg returns a Pkg representing the provider. It returns the Pkg and
+// nil, or nil and an error, if any.
+func (p *Provider) Pkg() (Pkg, error) {
+	switch p.Repo {
+	case "local":
+		return NewLocalPkg(p.Name)
+	case "aur":
+		return NewAURPkg(p.info)
+	}
+
+	return NewPacmanPkg(p.Name)
+}
+
+// providerCache caches the providers read from pacman, since reading
+// them is expensive.
+type providerCache struct {
+	once  sync.Once
+	provs []Provider
+	err   error
+}
+
+var (
+	localProviders providerCache
+	syncProviders  providerCache
+)
+
+// get returns the cached providers, reading them by running pacman
+// with the given args if they haven't been read yet.
+func (pc *providerCache) get(repo string, args ...string) ([]Provider, error) {
+	pc.once.Do(func() {
+		pc.provs, pc.err = readProviders(repo, args...)
+	})
+
+	return pc.provs, pc.err
+}
+
+// readProviders reads the name, version, repo, and provides list of
+// every package listed by running pacman with the given args, which
+// should be either -Qi or -Si. If repo is not "", it is used instead
+// of the package's Repository field. It returns the list and nil, or
+// nil and an error, if any.
+func readProviders(repo string, args ...string) ([]Provider, error) {
+	lines, err := PacmanLines(false, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var provs []Provider
+	var cur *Provider
+	var key string
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			cur = nil
+			continue
+		}
+
+		// Long lists are continued on the following lines.
+		val := line
+		if (line[0] != ' ') && (line[0] != '\t') {
+			parts := bytes.SplitN(line, []byte{':'}, 2)
+			if len(parts) != 2 {
+				continue
+			}
+			key = string(bytes.TrimSpace(parts[0]))
+			val = parts[1]
+		}
+		val = bytes.TrimSpace(val)
+
+		if cur == nil {
+			provs = append(provs, Provider{Repo: repo})
+			cur = &provs[len(provs)-1]
+		}
+
+		switch key {
+		case "Repository":
+			if repo == "" {
+				cur.Repo = string(val)
+			}
+		case "Name":
+			cur.Name = string(val)
+		case "Version":
+			cur.Version = string(val)
+		case "Provides":
+			if string(val) == "None" {
+				break
+			}
+			for _, prov := range bytes.Fields(val) {
+				cur.Provides = append(cur.Provides, string(prov))
+			}
+		}
+	}
+
+	return provs, nil
+}
+
+// localDBProviders returns a Provider for every installed package,
+// read from the local database, and nil, or nil and an error, if any.
+func localDBProviders() ([]Provider, error) {
+	db, err := LocalDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	names := db.Names()
+	provs := make([]Provider, 0, len(names))
+	for _, name := range names {
+		pkg, _ := db.Get(name)
+		provs = append(provs, Provider{
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Repo:     "local",
+			Provides: pkg.Provides,
+		})
+	}
+
+	return provs, nil
+}
+
+// syncDBProviders returns a Provider for every package in the sync
+// databases and nil, or nil and an error, if any.
+func syncDBProviders() ([]Provider, error) {
+	db, err := SyncDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	all := db.All()
+	provs := make([]Provider, 0, len(all))
+	for _, pkg := range all {
+		provs = append(provs, Provider{
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Repo:     pkg.Repo,
+			Provides: pkg.Provides,
+		})
+	}
+
+	return provs, nil
+}
+
+// Providers returns every package that satisfies dep, either by name
+// or through its provides list. Installed packages come first,
+// followed by packages in the sync databases and then packages in the
+// AUR. It returns the list and nil, or nil and an error, if any.
+func Providers(dep Dep) ([]Provider, error) {
+	var provs []Provider
+
+	local, err := localDBProviders()
+	if err != nil {
+		local, err = localProviders.get("local", "-Qi")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range local {
+		if local[i].Satisfies(dep) {
+			provs = append(provs, local[i])
+		}
+	}
+
+	repo, err := syncDBProviders()
+	if err != nil {
+		repo, err = syncProviders.get("", "-Si")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range repo {
+		if repo[i].Satisfies(dep) {
+			provs = append(provs, repo[i])
+		}
+	}
+
+	found, err := AURSearchBy("provides", dep.Name)
+	if err != nil {
+		if re, ok := err.(*RPCError); !ok || (re.Err != "No results found") {
+			return nil, err
+		}
+	}
+
+	// Search results don't include the provides lists, so the full
+	// info is needed to check them.
+	names := make([]string, 0, len(found))
+	for i := range found {
+		names = append(names, found[i].Name)
+	}
+	infos, err := AURInfoMulti(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		info, ok := infos[name]
+		if !ok {
+			continue
+		}
+
+		prov := Provider{
+			Name:     info.Name,
+			Version:  info.Version,
+			Repo:     "aur",
+			Provides: info.Provides,
+			info:     info,
+		}
+		if prov.Satisfies(dep) {
+			provs = append(provs, prov)
+		}
+	}
+
+	return provs, nil
+}
+
+// FindProvider finds a package that satisfies dep. If one is already
+// installed, it is used. Otherwise, if there are several available,
+// the user is asked to pick one, much like pacman does. It returns the
+// Pkg and nil, or nil and an error, if any. If nothing satisfies dep,
+// the error is a *PkgNotFoundError.
+func FindProvider(dep Dep) (Pkg, error) {
+	provs, err := Providers(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(provs) == 0:
+		return nil, &PkgNotFoundError{dep.String()}
+	case (len(provs) == 1) || (provs[0].Repo == "local"):
+		return provs[0].Pkg()
+	}
+
+	Cprintf("[c5]:: [c1]There are %v providers available for %v:[ce]\n", len(provs), dep)
+	var repo string
+	for i := range provs {
+		if provs[i].Repo != repo {
+			if repo != "" {
+				Cprintf("\n")
+			}
+			repo = provs[i].Repo
+			Cprintf("[c5]:: [c1]Repository %v[ce]\n  ", repo)
+		}
+		Cprintf(" %v) %v", i+1, provs[i].Name)
+	}
+	Cprintf("\n\n")
+
+	// Installed providers come first, so if one was installed it
+	// would already have been used.
+	n, err := Cnumberf(1, len(provs), "Enter a number")
+	if err != nil {
+		return nil, err
+	}
+
+	return provs[n-1].Pkg()
+}
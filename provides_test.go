@@ -0,0 +1,63 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestProvidersAUR(t *testing.T) {
+	newFakeAUR(t,
+		AURPackage{Name: "jre-foo", Version: "17.0-1", Provides: []string{"java-runtime=17"}},
+		AURPackage{Name: "jre-old", Version: "8.0-1", Provides: []string{"java-runtime=8"}},
+	)
+	writeFakeDB(t, nil)
+
+	tests := []struct {
+		dep   string
+		names []string
+	}{
+		{"java-runtime", []string{"jre-foo", "jre-old"}},
+		{"java-runtime>=11", []string{"jre-foo"}},
+		{"java-runtime<8", nil},
+	}
+	for _, test := range tests {
+		provs, err := Providers(ParseDep(test.dep))
+		if err != nil {
+			t.Errorf("%v: %v", test.dep, err)
+			continue
+		}
+
+		found := make(map[string]bool, len(provs))
+		for _, prov := range provs {
+			if prov.Repo != "aur" {
+				t.Errorf("%v: %v is from %v", test.dep, prov.Name, prov.Repo)
+			}
+			found[prov.Name] = true
+		}
+		if len(found) != len(test.names) {
+			t.Errorf("%v: Got %v, expected %v", test.dep, provs, test.names)
+			continue
+		}
+		for _, name := range test.names {
+			if !found[name] {
+				t.Errorf("%v: %v not found", test.dep, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func init() {
+	runQueryUpdates := func(args ...string) error {
+		aurOnly := args[0] == "-Qua"
+
+		var quiet bool
+		var pacargs []string
+		for _, arg := range args[1:] {
+			switch arg {
+			case "-q", "--quiet":
+				quiet = true
+			default:
+				if aurOnly {
+					return &UsageError{arg}
+				}
+			}
+			pacargs = append(pacargs, arg)
+		}
+
+		var found bool
+		if !aurOnly {
+			// pacman exits with 1 if nothing is out of date.
+			err := Pacman(append([]string{"-Qu"}, pacargs...)...)
+			if err != nil {
+				e, ok := err.(*exec.ExitError)
+				if !ok {
+					return err
+				}
+				if ws, ok := e.Sys().(syscall.WaitStatus); !ok || (ws.ExitStatus() != 1) {
+					return err
+				}
+			} else {
+				found = true
+			}
+		}
+
+		conf, err := PacmanConfig()
+		if err != nil {
+			return err
+		}
+
+		ups, err := CheckAURUpdates()
+		if err != nil {
+			return err
+		}
+
+		for _, up := range ups {
+			if !up.Newer {
+				continue
+			}
+			found = true
+
+			if quiet {
+				Cprintf("%v\n", up.Info.Name)
+				continue
+			}
+
+			ignored := ""
+			if conf.Ignored(up.Info.Name, up.Groups()) {
+				ignored = " [ignored]"
+			}
+
+			Cprintf("[c1]%v [c7]%v[ce] => [c2]%v[ce]%v\n",
+				up.Info.Name,
+				up.Installed,
+				up.Info.Version,
+				ignored,
+			)
+		}
+
+		if !found {
+			return ExitStatus(1)
+		}
+
+		return nil
+	}
+
+	RegisterCmd("-Qu", &Cmd{
+		Help:      "List packages that are out of date.",
+		UsageLine: "-Qu [pacman opts]",
+		HelpMore: `-Qu runs pacman -Qu, and then lists installed AUR packages that have
+newer versions in the AUR, along with the installed and available
+versions. Nothing is installed. With -q, only the names of the
+packages are listed. Like pacman, it exits with a status of 1 if
+nothing is out of date.
+
+See also: -Qua
+`,
+		Run: runQueryUpdates,
+	})
+
+	RegisterCmd("-Qua", &Cmd{
+		Help:      "List AUR packages that are out of date.",
+		UsageLine: "-Qua [-q]",
+		HelpMore: `-Qua is like -Qu, but it only lists AUR packages. It accepts no
+arguments other than -q.
+
+See also: -Qu
+`,
+		Run: runQueryUpdates,
+	})
+}
@@ -0,0 +1,381 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReviewedDir returns the directory where the last reviewed copy of
+// the build files for the given package base are kept and nil, or ""
+// and an error if base isn't a valid package base.
+func ReviewedDir(base string) (string, error) {
+	err := CheckPkgName(base)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(CacheDir, "reviewed", base), nil
+}
+
+// buildFiles returns the paths, relative to p.dir, of p's build
+// files. If p was cloned with git, these are the files tracked by git.
+// Otherwise, they're the PKGBUILD, the install script, and any local
+// sources. It returns the paths and nil, or nil and an error, if any.
+func (p *AURPkg) buildFiles() ([]string, error) {
+	if _, err := os.Stat(filepath.Join(p.dir, ".git")); err == nil {
+		out, err := GitOutput(p.dir, "ls-files", "-z")
+		if err == nil {
+			var files []string
+			for _, file := range strings.Split(string(out), "\x00") {
+				if file != "" {
+					files = append(files, file)
+				}
+			}
+			sort.Strings(files)
+
+			return files, nil
+		}
+	}
+
+	files := []string{"PKGBUILD"}
+	for _, t := range p.targets() {
+		if t.pkgbuild.HasInstall() {
+			files = append(files, t.pkgbuild.Install)
+		}
+	}
+	for _, src := range p.pkgbuild.Sources {
+		if strings.Contains(src, "://") {
+			continue
+		}
+		if parts := strings.SplitN(src, "::", 2); len(parts) == 2 {
+			src = parts[1]
+		}
+		files = append(files, src)
+	}
+
+	var existing []string
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		if _, err := os.Stat(filepath.Join(p.dir, file)); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	sort.Strings(existing)
+
+	return existing, nil
+}
+
+// reviewedFiles returns the paths, relative to revdir, of every file
+// in it, and an error, if any.
+func reviewedFiles(revdir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(revdir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(revdir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+
+		return nil
+	})
+
+	return files, err
+}
+
+// changedFiles compares the given files in dir with the reviewed
+// copies in revdir. It returns the files that were added, changed, or
+// removed since they were reviewed, and nil, or nil and an error, if
+// any.
+func changedFiles(dir, revdir string, files []string) ([]string, error) {
+	old, err := reviewedFiles(revdir)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]bool, len(files)+len(old))
+	for _, file := range files {
+		all[file] = true
+	}
+	for _, file := range old {
+		all[file] = true
+	}
+
+	var changed []string
+	for file := range all {
+		cur, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if (err != nil) && !os.IsNotExist(err) {
+			return nil, err
+		}
+		rev, err := ioutil.ReadFile(filepath.Join(revdir, file))
+		if (err != nil) && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if !bytes.Equal(cur, rev) {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+
+	return changed, nil
+}
+
+// diffFiles returns a unified diff of the given files between revdir
+// and dir, and an error, if any.
+func diffFiles(dir, revdir string, files []string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, file := range files {
+		out, err := DiffOutput("-u", "-N",
+			"--label", "a/"+file,
+			"--label", "b/"+file,
+			filepath.Join(revdir, file),
+			filepath.Join(dir, file),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(out)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// markReviewed replaces the reviewed copy of the build files in revdir
+// with the given files from dir. It returns an error, if any.
+func markReviewed(dir, revdir string, files []string) error {
+	err := os.RemoveAll(revdir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err := copyFile(filepath.Join(revdir, file), filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.MkdirAll(revdir, 0755)
+}
+
+// copyFile copies the file at src to dst, creating any directories
+// that dst needs. It returns an error, if any.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// review asks whether to use a cached package for p, if there is one.
+// If not, it shows what has changed in p's build files since they were
+// last reviewed, offers to edit the PKGBUILD and install script, and
+// then asks whether to mark the files as reviewed. If nothing has
+// changed since they were last reviewed, it doesn't ask anything. It
+// returns an error, if any.
+func (p *AURPkg) review() (err error) {
+	p.useCached = false
+	if p.builtPkgs() != nil {
+		p.useCached, err = Caskf(AskCached, true, "[c1]", "[c5]:: [c1]Found cached package for [c5]%v[c1]. Use it?[ce]", p.targetNames())
+		if err != nil {
+			return err
+		}
+		if p.useCached {
+			return nil
+		}
+	}
+
+	p.resume = false
+	if unfinishedBuild(p.Base(), p.pkgbuild.VersionString()) {
+		p.resume, err = Caskf(AskResume, true, "[c1]", "[c5]:: [c1]The last build of [c5]%v [c1]didn't finish. Resume it?[ce]", p.Base())
+		if err != nil {
+			return err
+		}
+	}
+
+	// If nobody's there to review the build files, they're left alone
+	// and not marked as reviewed.
+	if _, ok := Answers[AskEdit]; !ok && !Interactive() {
+		return nil
+	}
+
+	files, err := p.buildFiles()
+	if err != nil {
+		return err
+	}
+
+	revdir, err := ReviewedDir(p.Base())
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(revdir); err == nil {
+		changed, err := changedFiles(p.dir, revdir, files)
+		if err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			Cprintf("[c2]==> [c1]No changes to [c5]%v [c1]since it was last reviewed.[ce]\n", p.Base())
+			return nil
+		}
+
+		answer, err := Caskf(AskEdit, true, "[c1]", "[c5]:: [c1]View changes to [c5]%v [c1]since it was last reviewed?[ce]", p.Base())
+		if err != nil {
+			return err
+		}
+		if answer {
+			diff, err := diffFiles(p.dir, revdir, changed)
+			if err != nil {
+				Cprintf("[c6]warning:[ce] Can't show changes (%v). Changed files: %v\n", err, strings.Join(changed, " "))
+			} else {
+				err = Page(diff)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if EditPath != "" {
+		err = p.edit()
+		if err != nil {
+			return err
+		}
+	}
+
+	answer, err := Caskf(AskEdit, true, "[c1]", "[c5]:: [c1]Mark [c5]%v [c1]as reviewed?[ce]", p.Base())
+	if err != nil {
+		return err
+	}
+	if answer {
+		// Editing may have changed which files there are.
+		files, err = p.buildFiles()
+		if err != nil {
+			return err
+		}
+
+		return markReviewed(p.dir, revdir, files)
+	}
+
+	return nil
+}
+
+// edit offers to edit p's PKGBUILD and install script. Each is offered
+// again after it's edited, unless the answer was given with --ask. It
+// returns an error, if any.
+func (p *AURPkg) edit() error {
+	for {
+		answer, err := Caskf(AskEdit, false, "[c1]", "[c5]:: [c1]Edit [c5]%v[c1]'s [c5]PKGBUILD [c1]using [c5]%v?[ce]",
+			p.Base(),
+			filepath.Base(EditPath),
+		)
+		if err != nil {
+			return err
+		}
+		if !answer {
+			break
+		}
+
+		err = Edit(filepath.Join(p.dir, "PKGBUILD"))
+		if err != nil {
+			return err
+		}
+		err = p.reload()
+		if err != nil {
+			return err
+		}
+
+		if Preanswered(AskEdit) {
+			break
+		}
+	}
+
+	if !p.pkgbuild.HasInstall() {
+		return nil
+	}
+
+	install := filepath.Join(p.dir, p.pkgbuild.Install)
+	if _, err := os.Stat(install); err != nil {
+		Cprintf("[c6]warning:[ce] Can't find %v install script.\n", install)
+		return nil
+	}
+
+	for {
+		answer, err := Caskf(AskEdit, false, "[c1]", "[c5]:: [c1]Edit [c5]%v [c1]using [c5]%v?[ce]",
+			p.pkgbuild.Install,
+			filepath.Base(EditPath),
+		)
+		if err != nil {
+			return err
+		}
+		if !answer {
+			break
+		}
+
+		err = Edit(install)
+		if err != nil {
+			return err
+		}
+
+		if Preanswered(AskEdit) {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseSrcinfo parses a .SRCINFO file read from r. Unlike
+// ParsePkgbuild(), it doesn't run anything. It returns a *Pkgbuild
+// for each pkgname section, with the values from the pkgbase section
+// used as defaults, and nil, or nil and an error, if any.
+func ParseSrcinfo(r io.Reader) ([]*Pkgbuild, error) {
+	lines, err := ReadLines(r, true)
+	if err != nil {
+		return nil, err
+	}
+
+	base := new(Pkgbuild)
+
+	var pbs []*Pkgbuild
+	var cur *Pkgbuild
+	var overridden map[string]bool
+	for i, line := range lines {
+		if (len(line) == 0) || (line[0] == '#') {
+			continue
+		}
+
+		parts := bytes.SplitN(line, []byte{'='}, 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(".SRCINFO:%v: Expected 'key = value'.", i+1)
+		}
+		key := string(bytes.TrimSpace(parts[0]))
+		val := string(bytes.TrimSpace(parts[1]))
+
+		switch key {
+		case "pkgbase":
+			if (base.Base != "") || (cur != nil) {
+				return nil, fmt.Errorf(".SRCINFO:%v: Unexpected pkgbase.", i+1)
+			}
+			if err := CheckPkgName(val); err != nil {
+				return nil, fmt.Errorf(".SRCINFO:%v: %v", i+1, err)
+			}
+
+			base.Base = val
+			continue
+		case "pkgname":
+			if base.Base == "" {
+				return nil, fmt.Errorf(".SRCINFO:%v: pkgname before pkgbase.", i+1)
+			}
+			if err := CheckPkgName(val); err != nil {
+				return nil, fmt.Errorf(".SRCINFO:%v: %v", i+1, err)
+			}
+
+			cur = new(Pkgbuild)
+			*cur = *base
+			cur.Name = val
+			cur.ArchLists = make(map[string][]string, len(base.ArchLists))
+			for k, v := range base.ArchLists {
+				cur.ArchLists[k] = v
+			}
+			pbs = append(pbs, cur)
+
+			overridden = make(map[string]bool)
+			continue
+		}
+
+		if base.Base == "" {
+			return nil, fmt.Errorf(".SRCINFO:%v: %v is outside of a section.", i+1, key)
+		}
+
+		target := base
+		if cur != nil {
+			target = cur
+		}
+
+		if i := strings.Index(key, "_"); (i >= 0) && isArchList(key[:i]) {
+			if (cur != nil) && !overridden[key] {
+				delete(target.ArchLists, key)
+				overridden[key] = true
+			}
+			if target.ArchLists == nil {
+				target.ArchLists = make(map[string][]string)
+			}
+			if val != "" {
+				target.ArchLists[key] = append(target.ArchLists[key], val)
+			}
+
+			continue
+		}
+
+		if field := target.list(key); field != nil {
+			// Lists in pkgname sections replace the pkgbase ones
+			// entirely.
+			if (cur != nil) && !overridden[key] {
+				*field = nil
+				overridden[key] = true
+			}
+			if val != "" {
+				*field = append(*field, val)
+			}
+
+			continue
+		}
+
+		err := target.setSrcinfo(key, val)
+		if err != nil {
+			return nil, fmt.Errorf(".SRCINFO:%v: %v", i+1, err)
+		}
+	}
+
+	if len(pbs) == 0 {
+		return nil, errors.New(".SRCINFO doesn't have a pkgname.")
+	}
+
+	names := make([]string, 0, len(pbs))
+	for _, pb := range pbs {
+		names = append(names, pb.Name)
+	}
+
+	for _, pb := range pbs {
+		pb.Names = names
+		pb.mergeArch()
+
+		for _, src := range pb.Sources {
+			if vcs := sourceVCS(src); vcs != "" {
+				pb.VCS = vcs
+				break
+			}
+		}
+
+		err = pb.fillDefaults()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pbs, nil
+}
+
+// FindPkgbuild returns the *Pkgbuild for the named package from a
+// list of the packages in a package base. It returns the *Pkgbuild
+// and nil, or nil and an error, if any.
+func FindPkgbuild(pbs []*Pkgbuild, name string) (*Pkgbuild, error) {
+	for _, pb := range pbs {
+		if pb.Name == name {
+			return pb, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%v isn't built by its PKGBUILD.", name)
+}
+
+// isArchList returns true if the named list can have architecture
+// specific versions.
+func isArchList(name string) bool {
+	for _, n := range archListNames {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setSrcinfo sets the single-valued field in the *Pkgbuild that
+// corresponds to the given .SRCINFO key. Unknown keys are ignored. It
+// returns an error, if any.
+func (pb *Pkgbuild) setSrcinfo(key, val string) error {
+	switch key {
+	case "pkgver":
+		pb.Version = val
+	case "pkgrel":
+		if !validRelease(val) {
+			return fmt.Errorf("Got bad pkgrel: %v.", val)
+		}
+		pb.Release = val
+	case "epoch":
+		epoch, err := strconv.ParseInt(val, 10, 0)
+		if err != nil {
+			return fmt.Errorf("Got bad epoch: %v.", val)
+		}
+		pb.Epoch = int(epoch)
+	case "url":
+		pb.URL = val
+	case "pkgdesc":
+		pb.Description = val
+	case "install":
+		pb.Install = val
+	}
+
+	return nil
+}
+
+// sourceVCS returns the name of the VCS that the given source array
+// entry is fetched with, or "" if it isn't a VCS source.
+func sourceVCS(src string) string {
+	if i := strings.Index(src, "::"); i >= 0 {
+		src = src[i+2:]
+	}
+
+	scheme := src
+	if i := strings.Index(scheme, "://"); i >= 0 {
+		scheme = scheme[:i]
+	} else {
+		return ""
+	}
+	if i := strings.Index(scheme, "+"); i >= 0 {
+		scheme = scheme[:i]
+	}
+
+	switch scheme {
+	case "bzr", "fossil", "git", "hg", "svn":
+		return scheme
+	}
+
+	return ""
+}
+
+// SrcinfoPath returns the path of the .SRCINFO file that belongs to
+// the PKGBUILD at the given path.
+func SrcinfoPath(pkgbuild string) string {
+	return filepath.Join(filepath.Dir(pkgbuild), ".SRCINFO")
+}
+
+// LoadPkgbuild loads the PKGBUILD at the given path. If the path is
+// a .SRCINFO file or there is a .SRCINFO file next to the PKGBUILD,
+// that is parsed instead. Otherwise, the PKGBUILD is only parsed if
+// allowBash is true, since doing so runs it. It returns a *Pkgbuild
+// for each package that the PKGBUILD builds and nil, or nil and an
+// error, if any.
+func LoadPkgbuild(path string, allowBash bool) ([]*Pkgbuild, error) {
+	srcinfo := path
+	if filepath.Base(path) != ".SRCINFO" {
+		srcinfo = SrcinfoPath(path)
+	}
+
+	file, err := os.Open(srcinfo)
+	if err == nil {
+		defer file.Close()
+		return ParseSrcinfo(file)
+	}
+	if !os.IsNotExist(err) || (srcinfo == path) {
+		return nil, err
+	}
+
+	if !allowBash {
+		return nil, fmt.Errorf("Can't find %v. Run 'makepkg --printsrcinfo > .SRCINFO' or use --allowbash.", srcinfo)
+	}
+
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pb, err := ParsePkgbuild(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values set inside of package functions can't be read this way,
+	// so every package gets the same info.
+	pbs := make([]*Pkgbuild, 0, len(pb.Names))
+	for _, name := range pb.Names {
+		err := CheckPkgName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		sub := new(Pkgbuild)
+		*sub = *pb
+		sub.Name = name
+		pbs = append(pbs, sub)
+	}
+
+	return pbs, nil
+}
+
+// MakepkgSrcinfo generates and parses the .SRCINFO for the PKGBUILD
+// in the given dir using makepkg. Since makepkg sources the PKGBUILD
+// to do this, it should only be used on PKGBUILDs that are about to
+// be built anyway. It returns a *Pkgbuild for each package that the
+// PKGBUILD builds and nil, or nil and an error, if any.
+func MakepkgSrcinfo(dir string) ([]*Pkgbuild, error) {
+	out, err := MakepkgOutput(dir, "--printsrcinfo")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSrcinfo(bytes.NewReader(out))
+}
@@ -0,0 +1,187 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitSrcinfo is a .SRCINFO for a split package with architecture
+// specific lists, some of which are overridden by its packages.
+const splitSrcinfo = `# Generated by makepkg.
+pkgbase = split
+	pkgdesc = Shared description
+	pkgver = 1.0
+	pkgrel = 2
+	arch = x86_64
+	arch = aarch64
+	makedepends = make
+	depends = base-dep
+	depends_x86_64 = base-x86
+	depends_aarch64 = base-arm
+	source = https://example.com/split-1.0.tar.gz
+
+pkgname = split-a
+
+pkgname = split-b
+	pkgdesc = Second package
+	depends = b-dep
+	depends_x86_64 = b-x86
+	provides = split=1.0
+`
+
+func TestParseSrcinfoRelease(t *testing.T) {
+	tests := []struct {
+		rel string
+		ok  bool
+	}{
+		{"1", true},
+		{"12", true},
+		{"1.1", true},
+		{"", false},
+		{"1.", false},
+		{"1.1.1", false},
+		{"1a", false},
+		{"-1", false},
+	}
+
+	for _, test := range tests {
+		pbs, err := ParseSrcinfo(strings.NewReader("pkgbase = foo\n" +
+			"\tpkgver = 2.0\n" +
+			"\tpkgrel = " + test.rel + "\n" +
+			"\tepoch = 1\n" +
+			"\tarch = any\n" +
+			"\n" +
+			"pkgname = foo\n",
+		))
+		if !test.ok {
+			if err == nil {
+				t.Errorf("pkgrel %q: Expected an error", test.rel)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("pkgrel %q: %v", test.rel, err)
+			continue
+		}
+		if ver := pbs[0].VersionString(); ver != "1:2.0-"+test.rel {
+			t.Errorf("pkgrel %q: Got version %v", test.rel, ver)
+		}
+	}
+}
+
+func TestParseSrcinfoName(t *testing.T) {
+	tests := []struct {
+		base, name string
+		ok         bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foo.bar", true},
+		{"../../..", "foo", false},
+		{"foo/bar", "foo", false},
+		{".foo", "foo", false},
+		{"foo", "..", false},
+		{"foo", "a..b", false},
+		{"foo", "../foo", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSrcinfo(strings.NewReader("pkgbase = " + test.base + "\n" +
+			"\tpkgver = 1.0\n" +
+			"\tpkgrel = 1\n" +
+			"\tarch = any\n" +
+			"\n" +
+			"pkgname = " + test.name + "\n",
+		))
+		if test.ok != (err == nil) {
+			t.Errorf("%q/%q: Got %v", test.base, test.name, err)
+		}
+	}
+}
+
+func TestParseSrcinfoSplit(t *testing.T) {
+	old := CARCHOverride
+	defer func() { CARCHOverride = old }()
+	CARCHOverride = "x86_64"
+
+	pbs, err := ParseSrcinfo(strings.NewReader(splitSrcinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 2 {
+		t.Fatalf("Got %v packages", len(pbs))
+	}
+
+	a, b := pbs[0], pbs[1]
+	if (a.Name != "split-a") || (b.Name != "split-b") || (a.Base != "split") || (b.Base != "split") {
+		t.Errorf("Got names %v/%v and %v/%v", a.Base, a.Name, b.Base, b.Name)
+	}
+	if want := []string{"split-a", "split-b"}; !reflect.DeepEqual(a.Names, want) || !reflect.DeepEqual(b.Names, want) {
+		t.Errorf("Got Names %v and %v", a.Names, b.Names)
+	}
+	if (a.Description != "Shared description") || (b.Description != "Second package") {
+		t.Errorf("Got descriptions %q and %q", a.Description, b.Description)
+	}
+	if (a.VersionString() != "1.0-2") || (b.VersionString() != "1.0-2") {
+		t.Errorf("Got versions %v and %v", a.VersionString(), b.VersionString())
+	}
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"split-a depends", a.Deps, []string{"base-dep", "base-x86"}},
+		{"split-b depends", b.Deps, []string{"b-dep", "b-x86"}},
+		{"split-a makedepends", a.MakeDeps, []string{"make"}},
+		{"split-b makedepends", b.MakeDeps, []string{"make"}},
+		{"split-a provides", a.Provides, []string{"None"}},
+		{"split-b provides", b.Provides, []string{"split=1.0"}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%v: Got %q, expected %q", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestParseSrcinfoArch(t *testing.T) {
+	old := CARCHOverride
+	defer func() { CARCHOverride = old }()
+	CARCHOverride = "aarch64"
+
+	pbs, err := ParseSrcinfo(strings.NewReader(splitSrcinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each list is overridden separately, so split-b still gets the
+	// pkgbase's depends_aarch64.
+	if want := []string{"base-dep", "base-arm"}; !reflect.DeepEqual(pbs[0].Deps, want) {
+		t.Errorf("split-a: Got %q, expected %q", pbs[0].Deps, want)
+	}
+	if want := []string{"b-dep", "base-arm"}; !reflect.DeepEqual(pbs[1].Deps, want) {
+		t.Errorf("split-b: Got %q, expected %q", pbs[1].Deps, want)
+	}
+	if vals := pbs[1].ArchLists["depends_x86_64"]; !reflect.DeepEqual(vals, []string{"b-x86"}) {
+		t.Errorf("split-b: Got depends_x86_64 %q", vals)
+	}
+}
@@ -21,15 +21,66 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 func init() {
 	var flags struct {
 		// Whether or not development package updates should be forced.
 		UpdateVCS bool
+
+		// Packages and groups to ignore in addition to those listed in
+		// pacman.conf.
+		Ignore      []string
+		IgnoreGroup []string
+	}
+
+	// parseIgnore removes --ignore and --ignoregroup, in both their
+	// '--ignore pkg' and '--ignore=pkg' forms, from args and adds
+	// their comma separated values to flags. The flags are put back
+	// in their '--ignore=pkg' form so that pacman still sees them.
+	parseIgnore := func(args []string) ([]string, error) {
+		rest := make([]string, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
+			if arg == "--" {
+				return append(rest, args[i:]...), nil
+			}
+
+			var val string
+			switch {
+			case (arg == "--ignore") || (arg == "--ignoregroup"):
+				if i+1 >= len(args) {
+					return nil, &UsageError{arg}
+				}
+				i++
+				val = args[i]
+			case strings.HasPrefix(arg, "--ignore="), strings.HasPrefix(arg, "--ignoregroup="):
+				parts := strings.SplitN(arg, "=", 2)
+				arg, val = parts[0], parts[1]
+			default:
+				rest = append(rest, arg)
+				continue
+			}
+
+			list := &flags.Ignore
+			if arg == "--ignoregroup" {
+				list = &flags.IgnoreGroup
+			}
+			for _, item := range strings.Split(val, ",") {
+				if item != "" {
+					*list = append(*list, item)
+				}
+			}
+
+			rest = append(rest, arg+"="+val)
+		}
+
+		return rest, nil
 	}
 
 	parseFlags := func(args []string) []string {
@@ -69,6 +120,11 @@ func init() {
 pacman, and then installing any packages it can find in the AUR. It
 will fail if it can't find a package. All options are passed straight
 through to pacman.
+
+If any packages need to be built, a summary of everything that will
+be installed, including dependencies, is shown first. The build files
+of every AUR package can then be reviewed before any builds start, so
+that they can run unattended.
 `,
 		Run: func(args ...string) error {
 			args, pkgargs := SplitArgs(args[1:]...)
@@ -137,7 +193,7 @@ in the AUR. Unlike pacman, it will fail if given no arguments.
 			}
 		}
 
-		sc := make(chan RPCResult)
+		sc := make(chan []AURPackage)
 		errc := make(chan error)
 		go func() {
 			var search []string
@@ -168,35 +224,32 @@ in the AUR. Unlike pacman, it will fail if given no arguments.
 			}
 		}
 
-		info := <-sc
+		results := <-sc
 		err = <-errc
 		if err != nil {
-			if re, ok := err.(*RPCError); ok && re.Err == "No results found" {
-				return nil
-			}
 			return err
 		}
 
-		ic := make(chan string)
-		for i := range info.Results.([]interface{}) {
-			go func() {
+		for _, info := range results {
+			if args[0] != "-Ssq" {
 				installed := ""
-				if InLocal(info.GetSearch(i, "Name")) {
+				if InLocal(info.Name) {
 					installed = " [c4][installed][ce]"
 				}
+				outofdate := ""
+				if info.OutOfDate != 0 {
+					outofdate = " [c7](Out of Date)[ce]"
+				}
 
-				ic <- installed
-			}()
-
-			if args[0] != "-Ssq" {
-				Cprintf("[c3]aur/[c1]%v [c2]%v[ce]%v\n",
-					info.GetSearch(i, "Name"),
-					info.GetSearch(i, "Version"),
-					<-ic,
+				Cprintf("[c3]aur/[c1]%v [c2]%v[ce]%v%v\n",
+					info.Name,
+					info.Version,
+					installed,
+					outofdate,
 				)
-				Cprintf("    %v\n", info.GetSearch(i, "Description"))
+				Cprintf("    %v\n", info.Description)
 			} else {
-				Cprintf("%v\n", info.GetSearch(i, "Name"))
+				Cprintf("%v\n", info.Name)
 			}
 		}
 
@@ -223,14 +276,31 @@ only lists their names. Like -Ss, it will fail if given no arguments.
 	})
 
 	runUpdate := func(args ...string) error {
-		pacargs, _ := SplitArgs(args[1:]...)
+		rest, err := parseIgnore(args[1:])
+		if err != nil {
+			return err
+		}
+
+		pacargs, targets := SplitArgs(rest...)
 
 		pacargs = parseFlags(pacargs)
 
+		conf, err := PacmanConfig()
+		if err != nil {
+			return err
+		}
+		ignore := *conf
+		ignore.IgnorePkg = append(append([]string(nil), conf.IgnorePkg...), flags.Ignore...)
+		ignore.IgnoreGroup = append(append([]string(nil), conf.IgnoreGroup...), flags.IgnoreGroup...)
+
+		// Warnings about ignored packages are printed after pacman is
+		// done, so that they don't get mixed up with its output.
+		var ignored []string
+
 		ac := make(chan PkgList)
 		errc := make(chan error)
 		go func() {
-			fpkgs, err := ListForeignPkgs()
+			ups, err := CheckAURUpdates(targets...)
 			if err != nil {
 				ac <- nil
 				errc <- err
@@ -238,73 +308,79 @@ only lists their names. Like -Ss, it will fail if given no arguments.
 			}
 
 			var aurpkgs PkgList
+			var firstErr error
 			var apl sync.Mutex
 			var wg sync.WaitGroup
-			for _, pkg := range fpkgs {
-				wg.Add(1)
-				go func(pkg string) {
-					defer wg.Done()
+			for _, up := range ups {
+				if !up.Newer && !flags.UpdateVCS {
+					continue
+				}
 
-					if info, ok := InAUR(pkg); ok {
-						apkg, err := NewAURPkg(info)
+				if ignore.Ignored(up.Info.Name, up.Groups()) {
+					// pacman isn't run when there are targets, so it's safe
+					// to ask about them.
+					var anyway bool
+					if len(targets) != 0 {
+						anyway, err = Caskf(AskInstall, true, "[c1]", "[c5]:: [c1]%v is in IgnorePkg/IgnoreGroup. Install anyway?[ce]", up.Info.Name)
 						if err != nil {
 							ac <- nil
 							errc <- err
 							return
 						}
-						if (flags.UpdateVCS) && (apkg.IsVCS()) {
-							apl.Lock()
-							aurpkgs = append(aurpkgs, apkg)
-							apl.Unlock()
-						} else {
-							lpkg, err := NewLocalPkg(pkg)
-							if err != nil {
-								ac <- nil
-								errc <- err
-								return
-							}
-
-							ver1, err := apkg.Version()
-							if err != nil {
-								ac <- nil
-								errc <- err
-								return
-							}
-							ver2, err := lpkg.Version()
-							if err != nil {
-								ac <- nil
-								errc <- err
-								return
-							}
-							up, err := Newer(ver1, ver2)
-							if err != nil {
-								ac <- nil
-								errc <- err
-								return
-							}
-							if up {
-								apl.Lock()
-								aurpkgs = append(aurpkgs, apkg)
-								apl.Unlock()
-							}
+					}
+
+					if !anyway {
+						if up.Newer {
+							ignored = append(ignored, fmt.Sprintf("%v: ignoring package upgrade (%v => %v)", up.Info.Name, up.Installed, up.Info.Version))
 						}
+						continue
 					}
-				}(pkg)
+				}
+
+				wg.Add(1)
+				go func(up AURUpdate) {
+					defer wg.Done()
+
+					apkg, err := NewAURPkg(up.Info)
+					if err != nil {
+						apl.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						apl.Unlock()
+						return
+					}
+
+					if up.Newer || apkg.IsVCS() {
+						apl.Lock()
+						aurpkgs = append(aurpkgs, apkg)
+						apl.Unlock()
+					}
+				}(up)
 			}
 			wg.Wait()
 
+			if firstErr != nil {
+				ac <- nil
+				errc <- firstErr
+				return
+			}
+
 			ac <- aurpkgs
 			errc <- nil
 		}()
 
-		err := AsRootPacman(append([]string{args[0]}, pacargs...)...)
-		if err != nil {
-			<-ac
-			<-errc
-			return err
-		}
+		// Only the named AUR packages are upgraded if there are targets.
+		if len(targets) == 0 {
+			err = AsRootPacman(append([]string{args[0]}, pacargs...)...)
+			if err != nil {
+				<-ac
+				<-errc
+				return err
+			}
 
-		fmt.Println()
+			fmt.Println()
+		}
 		Cprintf("[c5]:: [c1]Calculating AUR updates...[ce]\n")
 
 		aurpkgs := <-ac
@@ -313,18 +389,21 @@ only lists their names. Like -Ss, it will fail if given no arguments.
 			return err
 		}
 
+		for _, warning := range ignored {
+			Cprintf("[c6]warning:[ce] %v\n", warning)
+		}
+
 		if aurpkgs == nil {
 			Cprintf(" there is nothing to do\n")
 			return nil
 		}
 
-		fmt.Println()
-		Cprintf("[c6]Targets (%v):[ce]", len(aurpkgs))
-		for _, pkg := range aurpkgs {
-			Cprintf(" %v", pkg.Name())
+		plan, err := NewPlan(aurpkgs, nil)
+		if err != nil {
+			return err
 		}
-		Cprintf("\n\n")
-		answer, err := Caskf(true, "[c1]", "[c5]:: [c1]Proceed with installation?[ce]")
+
+		answer, err := plan.Confirm()
 		if err != nil {
 			return err
 		}
@@ -332,40 +411,33 @@ only lists their names. Like -Ss, it will fail if given no arguments.
 			return nil
 		}
 
-		for _, pkg := range aurpkgs {
-			if ip, ok := pkg.(InstallPkg); ok {
-				isdep, err := IsDep(pkg.Name())
-				if err != nil {
-					return err
-				}
-				asdeps := ""
-				if isdep {
-					asdeps = "--asdeps"
-				}
-
-				err = ip.Install(nil, asdeps)
-				if err != nil {
-					Cprintf("[c6]warning:[ce] %v failed: %v\n", ip.Name(), err)
-				}
-			} else {
-				return fmt.Errorf("Don't know how to install %v.", pkg.Name())
-			}
+		err = plan.Review()
+		if err != nil {
+			return err
 		}
 
-		return nil
+		return plan.Run(nil, true)
 	}
 
 	RegisterCmd("-Su", &Cmd{
 		Help:      "Install updates.",
-		UsageLine: "-Su [opts]",
+		UsageLine: "-Su [opts] [packages]",
 		HelpMore: `-Su checks for updates to all installed, pacman and AUR, packages and
 downloads and installs them.
 
+If packages are given, only those packages are upgraded, along with
+any new AUR dependencies that they need, and pacman is not run. They
+must be installed AUR packages. Use pacman to upgrade specific repo
+packages.
+
 -Su also takes these non-pacman options:
 	--upvcs: Update VCS AUR packages.
 
-It is not capable of updating specific packages, but this
-functionality is intended.
+AUR packages matching IgnorePkg or IgnoreGroup in pacman.conf, or
+given with --ignore or --ignoregroup, are not upgraded. Like with
+pacman, the command line options add to the ones in pacman.conf for
+that run only. If an ignored package is given explicitly, it asks
+whether to upgrade it anyway.
 
 See also: -Syu
 `,
@@ -377,18 +449,85 @@ See also: -Syu
 		UsageLine: "-Syu [pacman opts]",
 		HelpMore: `-Syu is exactly like -Su, but it also updates the local pacman
 package databases. AUR updates are not affected.
+If packages are given, pacman isn't run, so it's the same as -Su.
 
 See also: -Su
 `,
 		Run: runUpdate,
 	})
 
+	RegisterCmd("-Sc", &Cmd{
+		Help:      "Clean old files.",
+		UsageLine: "-Sc [--keep <n>] [--age <duration>]",
+		HelpMore: `-Sc runs pacman -Sc and then prunes pacgo's build directory. The
+build directories of package bases that haven't been built in longer
+than --age are removed completely, along with their sources. For the
+others, only the newest --keep package files of each package are kept.
+The defaults are set by the BuildMaxAge and BuildKeep options in the
+config file. Directories that pacgo didn't create are left alone.
+Cached AUR responses older than CacheTTL are removed as well.
+
+See also: -Scc
+`,
+		Run: func(args ...string) error {
+			maxAge := BuildMaxAge
+			keep := BuildKeep
+			for i := 1; i < len(args); i++ {
+				if i+1 >= len(args) {
+					return &UsageError{args[i]}
+				}
+
+				var err error
+				switch args[i] {
+				case "--age":
+					maxAge, err = time.ParseDuration(args[i+1])
+					if maxAge < 0 {
+						err = fmt.Errorf("Age can't be negative: %v", args[i+1])
+					}
+				case "--keep":
+					keep, err = strconv.Atoi(args[i+1])
+					if keep < 0 {
+						err = fmt.Errorf("Can't keep %v packages.", keep)
+					}
+				default:
+					return &UsageError{args[i]}
+				}
+				if err != nil {
+					return err
+				}
+				i++
+			}
+
+			err := AsRootPacman(args[0])
+			if err != nil {
+				return err
+			}
+
+			fmt.Println()
+			Cprintf("[c1]Build directory:[ce] %v\n", BuildDir)
+			Cprintf("pruning packages older than %v, keeping %v of each...\n", maxAge, keep)
+			err = PruneBuilds(maxAge, keep)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println()
+			Cprintf("[c1]Cache directory:[ce] %v\n", CacheDir)
+			Cprintf("pruning AUR responses older than %v...\n", CacheTTL)
+			n, err := PruneCache()
+			Cprintf("removed %v cached responses\n", n)
+			return err
+		},
+	})
+
 	RegisterCmd("-Scc", &Cmd{
 		Help:      "Clean leftover files.",
 		UsageLine: "-Scc",
 		HelpMore: `-Scc is a convience command that runs pacman -Scc and then gives the
-option to remove pacgo's temporary directory. Unlike pacman, it
-accepts no arguments.
+option to remove pacgo's temporary directory and all of its builds.
+Unlike pacman, it accepts no arguments.
+
+See also: -Sc
 `,
 		Run: func(args ...string) error {
 			if len(args) != 1 {
@@ -402,7 +541,7 @@ accepts no arguments.
 
 			fmt.Println()
 			Cprintf("[c1]TmpDir:[ce] %v\n", TmpDir)
-			answer, err := Caskf(false, "[c1]", "[c5]:: [c1]Do you want to remove TmpDir?[ce]")
+			answer, err := Caskf(AskClean, false, "[c1]", "[c5]:: [c1]Do you want to remove TmpDir?[ce]")
 			if err != nil {
 				return err
 			}
@@ -414,6 +553,20 @@ accepts no arguments.
 				}
 			}
 
+			Cprintf("[c1]Build directory:[ce] %v\n", BuildDir)
+			answer, err = Caskf(AskClean, false, "[c1]", "[c5]:: [c1]Do you want to remove all builds?[ce]")
+			if err != nil {
+				return err
+			}
+			if answer {
+				// Only what pacgo made is removed, in case BuildDir
+				// was set to a directory that has other things in it.
+				err = PruneBuilds(0, 0)
+				if err != nil {
+					return err
+				}
+			}
+
 			return nil
 		},
 	})
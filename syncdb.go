@@ -0,0 +1,233 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyncDBPkg represents a package in one of pacman's sync databases.
+type SyncDBPkg struct {
+	Name        string
+	Version     string
+	Base        string
+	Description string
+
+	// Repo is the name of the repo that the package is in.
+	Repo string
+
+	// The sizes, in bytes, of the package file and of the installed
+	// package.
+	DownloadSize  int64
+	InstalledSize int64
+
+	Depends    []string
+	OptDepends []string
+	Provides   []string
+	Conflicts  []string
+	Replaces   []string
+	Groups     []string
+}
+
+// SyncDB is an index of the packages in pacman's sync databases.
+type SyncDB struct {
+	pkgs map[string]*SyncDBPkg
+	all  []*SyncDBPkg
+}
+
+// ReadSyncDB reads the sync databases for the given repos under the
+// given DBPath. If a package is in more than one repo, the one in the
+// repo listed first wins, as it does with pacman. It returns the
+// index and nil, or nil and an error, if any.
+func ReadSyncDB(dbpath string, repos []string) (*SyncDB, error) {
+	db := &SyncDB{
+		pkgs: make(map[string]*SyncDBPkg),
+	}
+
+	for _, repo := range repos {
+		pkgs, err := readSyncRepo(filepath.Join(dbpath, "sync", repo+".db"), repo)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range pkgs {
+			if _, ok := db.pkgs[pkg.Name]; !ok {
+				db.pkgs[pkg.Name] = pkg
+			}
+			db.all = append(db.all, pkg)
+		}
+	}
+
+	return db, nil
+}
+
+// readSyncRepo reads the packages from the sync database at the
+// given path, which is a tar archive, either uncompressed or gzipped,
+// containing a directory for each package. It returns the packages
+// and nil, or nil and an error, if any.
+func readSyncRepo(file, repo string) ([]*SyncDBPkg, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = br
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	descs := make(map[string]map[string][]string)
+	var dirs []string
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+
+		// Older databases split each package's info between desc and
+		// depends files.
+		switch path.Base(hdr.Name) {
+		case "desc", "depends":
+		default:
+			continue
+		}
+
+		desc, err := parseDesc(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := path.Dir(hdr.Name)
+		all, ok := descs[dir]
+		if !ok {
+			all = make(map[string][]string)
+			descs[dir] = all
+			dirs = append(dirs, dir)
+		}
+		for k, v := range desc {
+			all[k] = v
+		}
+	}
+
+	pkgs := make([]*SyncDBPkg, 0, len(dirs))
+	for _, dir := range dirs {
+		desc := descs[dir]
+		csize, _ := strconv.ParseInt(descValue(desc, "CSIZE"), 10, 64)
+		isize, _ := strconv.ParseInt(descValue(desc, "ISIZE"), 10, 64)
+		pkgs = append(pkgs, &SyncDBPkg{
+			Name:          descValue(desc, "NAME"),
+			Version:       descValue(desc, "VERSION"),
+			Base:          descValue(desc, "BASE"),
+			Description:   descValue(desc, "DESC"),
+			Repo:          repo,
+			DownloadSize:  csize,
+			InstalledSize: isize,
+			Depends:       desc["DEPENDS"],
+			OptDepends:    desc["OPTDEPENDS"],
+			Provides:      desc["PROVIDES"],
+			Conflicts:     desc["CONFLICTS"],
+			Replaces:      desc["REPLACES"],
+			Groups:        desc["GROUPS"],
+		})
+	}
+
+	return pkgs, nil
+}
+
+// Get returns the named package and true, or nil and false if it
+// isn't in any of the repos.
+func (db *SyncDB) Get(name string) (*SyncDBPkg, bool) {
+	pkg, ok := db.pkgs[name]
+	return pkg, ok
+}
+
+// All returns every package in every repo, in repo order. Packages
+// that are in more than one repo are listed once for each.
+func (db *SyncDB) All() []*SyncDBPkg {
+	return db.all
+}
+
+var syncDB struct {
+	sync.Mutex
+	db      *SyncDB
+	modTime time.Time
+}
+
+// SyncDatabase returns an index of the sync databases under DBPath()
+// for the repos listed in pacman.conf. Like LocalDatabase(), it's
+// cached, but it's reread if the databases are updated. It returns
+// the index and nil, or nil and an error, if any.
+func SyncDatabase() (*SyncDB, error) {
+	dbpath := DBPath()
+
+	fi, err := os.Stat(filepath.Join(dbpath, "sync"))
+	if err != nil {
+		return nil, err
+	}
+
+	syncDB.Lock()
+	defer syncDB.Unlock()
+
+	if (syncDB.db != nil) && fi.ModTime().Equal(syncDB.modTime) {
+		return syncDB.db, nil
+	}
+
+	conf, err := PacmanConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := ReadSyncDB(dbpath, conf.RepoNames())
+	if err != nil {
+		return nil, err
+	}
+
+	syncDB.db = db
+	syncDB.modTime = fi.ModTime()
+
+	return db, nil
+}
@@ -4,10 +4,14 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -55,9 +59,133 @@ func ReadLines(r io.Reader, trim bool) ([][]byte, error) {
 	return lines, nil
 }
 
-// ExtractTar extracts the contents of tr to the given dir. It
-// returns an error, if any.
+// UnsafeTarEntryError is returned by ExtractTar() when an archive
+// contains an entry that would be written outside of the directory
+// that it's being extracted to.
+type UnsafeTarEntryError struct {
+	Name   string
+	Reason string
+}
+
+func (err *UnsafeTarEntryError) Error() string {
+	return fmt.Sprintf("Unsafe entry in archive: %v (%v)", err.Name, err.Reason)
+}
+
+// tarPath returns the path in dir that the archive entry with the
+// given name should be extracted to. It returns an
+// *UnsafeTarEntryError if the path is outside of dir, or if any of
+// the directories leading to it inside of dir are symlinks.
+func tarPath(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(rel) {
+		return "", &UnsafeTarEntryError{name, "absolute path"}
+	}
+	if (rel == "..") || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &UnsafeTarEntryError{name, "outside of destination"}
+	}
+
+	// A symlink in an earlier entry could point anywhere.
+	parts := strings.Split(rel, string(filepath.Separator))
+	cur := dir
+	for _, part := range parts[:len(parts)-1] {
+		cur = filepath.Join(cur, part)
+
+		fi, err := os.Lstat(cur)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", &UnsafeTarEntryError{name, "path contains a symlink"}
+		}
+	}
+
+	return filepath.Join(dir, rel), nil
+}
+
+// maxLinkHops is the maximum number of symlinks that resolveInside()
+// follows before giving up, like the kernel's limit.
+const maxLinkHops = 40
+
+// resolveInside resolves the relative path rel, starting at the
+// directory start, against what's on disk in dir, following symlinks
+// one component at a time. Components that don't exist yet are
+// resolved lexically. It returns the resolved path and nil, or "" and
+// an error if the path leaves dir at any point.
+func resolveInside(dir, start, rel string) (string, error) {
+	if filepath.IsAbs(rel) {
+		return "", errors.New("absolute symlink")
+	}
+
+	cur := start
+	comps := strings.Split(filepath.ToSlash(rel), "/")
+	hops := 0
+	for len(comps) > 0 {
+		comp := comps[0]
+		comps = comps[1:]
+
+		switch comp {
+		case "", ".":
+			continue
+		case "..":
+			if cur == dir {
+				return "", errors.New("outside of destination")
+			}
+			cur = filepath.Dir(cur)
+			continue
+		}
+
+		next := filepath.Join(cur, comp)
+		fi, err := os.Lstat(next)
+		if (err != nil) || (fi.Mode()&os.ModeSymlink == 0) {
+			cur = next
+			continue
+		}
+
+		hops++
+		if hops > maxLinkHops {
+			return "", errors.New("too many levels of symlinks")
+		}
+
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			return "", errors.New("absolute symlink")
+		}
+		comps = append(strings.Split(filepath.ToSlash(link), "/"), comps...)
+	}
+
+	return cur, nil
+}
+
+// ExtractTar extracts the contents of tr to the given dir. Regular
+// files, directories, symlinks, and hardlinks are extracted with
+// their permissions and modification times. Other types of entries
+// are skipped. Nothing is ever written through a symlink: links in
+// the way of an entry are replaced, and links in its parent
+// directories are refused. If any entry would be written outside of
+// dir, or any symlink would point outside of it once everything is
+// extracted, it stops and returns an *UnsafeTarEntryError. It returns
+// an error, if any.
 func ExtractTar(dir string, tr *tar.Reader) error {
+	dir = filepath.Clean(dir)
+
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTime
+
+	type symlink struct {
+		name string
+		path string
+	}
+	var links []symlink
+
 	for {
 		hdr, err := tr.Next()
 		if err != nil {
@@ -67,31 +195,162 @@ func ExtractTar(dir string, tr *tar.Reader) error {
 			return err
 		}
 
-		if hdr.Typeflag == tar.TypeDir {
-			err = os.MkdirAll(filepath.Join(dir, hdr.Name), 0755)
+		path, err := tarPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(path); (err == nil) && !fi.IsDir() {
+				err = removeExisting(path)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = os.MkdirAll(path, mode|0700)
 			if err != nil {
 				return err
 			}
-		} else {
-			file, err := os.OpenFile(filepath.Join(dir, hdr.Name),
-				os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
-				os.FileMode(hdr.Mode),
-			)
+			err = os.Chmod(path, mode|0700)
 			if err != nil {
 				return err
 			}
-			defer file.Close()
 
-			_, err = io.Copy(file, tr)
+			// Extracting the directory's contents changes its
+			// modification time, so it's set at the end.
+			dirs = append(dirs, dirTime{path, hdr.ModTime})
+
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractTarFile(path, mode, tr)
+			if err != nil {
+				return err
+			}
+
+			err = os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			_, err := resolveInside(dir, filepath.Dir(path), hdr.Linkname)
+			if err != nil {
+				return &UnsafeTarEntryError{hdr.Name, "symlink: " + err.Error()}
+			}
+
+			err = removeExisting(path)
 			if err != nil {
 				return err
 			}
+			err = os.Symlink(hdr.Linkname, path)
+			if err != nil {
+				return err
+			}
+			links = append(links, symlink{hdr.Name, path})
+
+		case tar.TypeLink:
+			target, err := tarPath(dir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			fi, err := os.Lstat(target)
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return &UnsafeTarEntryError{hdr.Name, "hardlink to something other than a file"}
+			}
+
+			err = removeExisting(path)
+			if err != nil {
+				return err
+			}
+			err = os.Link(target, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// A later entry can change where an earlier symlink leads, so
+	// they're all checked again against the final state of dir.
+	for _, link := range links {
+		target, err := os.Readlink(link.path)
+		if err != nil {
+			return err
+		}
+		_, err = resolveInside(dir, filepath.Dir(link.path), target)
+		if err != nil {
+			os.Remove(link.path)
+			return &UnsafeTarEntryError{link.name, "symlink: " + err.Error()}
+		}
+	}
+
+	// Innermost directories go first, so that setting their times
+	// doesn't change their parents' times.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi, err := os.Lstat(dirs[i].path)
+		if (err != nil) || !fi.IsDir() {
+			continue
+		}
+
+		err = os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// removeExisting removes whatever is at path, unless it's a directory,
+// so that it can be replaced without following a symlink that's
+// there. It returns an error, if any.
+func removeExisting(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%v is a directory.", path)
+	}
+
+	return os.Remove(path)
+}
+
+// extractTarFile writes the contents of the current entry in tr to a
+// new file at path with the given permissions. It returns an error,
+// if any.
+func extractTarFile(path string, mode os.FileMode, tr *tar.Reader) error {
+	err := removeExisting(path)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, tr)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
 // SplitArgs is a convience function that seperates pkgs from other
 // arguments.
 func SplitArgs(args ...string) (pacargs []string, pkgs []string) {
@@ -117,30 +376,3 @@ func IsTerminal(fd int) bool {
 	_, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TCGETS), uintptr(unsafe.Pointer(&t)), 0, 0, 0)
 	return err == 0
 }
-
-// CheckConfOption checks if a given option is set in pacman.conf.
-//
-// TODO: Add support for configuration files that aren't located at
-//				/etc/pacman.conf.
-func CheckConfOption(opt string) (bool, error) {
-	file, err := os.Open("/etc/pacman.conf")
-	if err != nil {
-		return false, err
-	}
-	defer file.Close()
-
-	lines, err := ReadLines(file, true)
-	if err != nil {
-		return false, err
-	}
-
-	optS := []byte(opt)
-
-	for _, line := range lines {
-		if bytes.Equal(line, optS) {
-			return true, nil
-		}
-	}
-
-	return false, nil
-}
@@ -0,0 +1,220 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry describes a single entry in an archive built by makeTar().
+type tarEntry struct {
+	name string
+	typ  byte
+	link string
+	body string
+}
+
+// makeTar returns a *tar.Reader for an archive containing the given
+// entries.
+func makeTar(t *testing.T, entries ...tarEntry) *tar.Reader {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Linkname: e.link,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typ == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if e.typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.typ == tar.TypeReg {
+			_, err = tw.Write([]byte(e.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tar.NewReader(&buf)
+}
+
+func TestExtractTarSafe(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dest")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ExtractTar(dir, makeTar(t,
+		tarEntry{name: "pkg/", typ: tar.TypeDir},
+		tarEntry{name: "pkg/PKGBUILD", typ: tar.TypeReg, body: "pkgname=pkg"},
+		tarEntry{name: "pkg/link", typ: tar.TypeSymlink, link: "PKGBUILD"},
+		tarEntry{name: "pkg/hard", typ: tar.TypeLink, link: "pkg/PKGBUILD"},
+		tarEntry{name: "pkg/sub/../up", typ: tar.TypeReg, body: "up"},
+	))
+	if err != nil {
+		t.Fatalf("Failed to extract safe archive: %v", err)
+	}
+
+	for _, name := range []string{"pkg/PKGBUILD", "pkg/link", "pkg/hard"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if string(data) != "pkgname=pkg" {
+			t.Errorf("%v: Got %q", name, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg", "up")); err != nil {
+		t.Errorf("pkg/up: %v", err)
+	}
+}
+
+func TestExtractTarUnsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name: "dot dot",
+			entries: []tarEntry{
+				{name: "../outside", typ: tar.TypeReg, body: "bad"},
+			},
+		},
+		{
+			name: "nested dot dot",
+			entries: []tarEntry{
+				{name: "pkg/../../outside", typ: tar.TypeReg, body: "bad"},
+			},
+		},
+		{
+			name: "absolute path",
+			entries: []tarEntry{
+				{name: "/outside", typ: tar.TypeReg, body: "bad"},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []tarEntry{
+				{name: "link", typ: tar.TypeSymlink, link: "/"},
+			},
+		},
+		{
+			name: "symlink outside",
+			entries: []tarEntry{
+				{name: "link", typ: tar.TypeSymlink, link: "../"},
+			},
+		},
+		{
+			name: "symlink then file",
+			entries: []tarEntry{
+				{name: "pkg/", typ: tar.TypeDir},
+				{name: "link", typ: tar.TypeSymlink, link: "pkg"},
+				{name: "link/outside", typ: tar.TypeReg, body: "bad"},
+			},
+		},
+		{
+			name: "chained symlinks",
+			entries: []tarEntry{
+				{name: "c", typ: tar.TypeSymlink, link: "."},
+				{name: "b", typ: tar.TypeSymlink, link: "c/.."},
+				{name: "b/", typ: tar.TypeDir},
+			},
+		},
+		{
+			name: "symlink changed later",
+			entries: []tarEntry{
+				{name: "b", typ: tar.TypeSymlink, link: "c/.."},
+				{name: "c", typ: tar.TypeSymlink, link: "."},
+			},
+		},
+		{
+			name: "hardlink outside",
+			entries: []tarEntry{
+				{name: "hard", typ: tar.TypeLink, link: "../outside"},
+			},
+		},
+		{
+			name: "hardlink through symlink",
+			entries: []tarEntry{
+				{name: "pkg/", typ: tar.TypeDir},
+				{name: "link", typ: tar.TypeSymlink, link: "pkg"},
+				{name: "hard", typ: tar.TypeLink, link: "link/outside"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			outside := filepath.Join(parent, "outside")
+			err := ioutil.WriteFile(outside, []byte("good"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := filepath.Join(parent, "dest")
+			err = os.Mkdir(dir, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.Stat(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ExtractTar(dir, makeTar(t, test.entries...))
+			if _, ok := err.(*UnsafeTarEntryError); !ok {
+				t.Errorf("Expected *UnsafeTarEntryError, got %v", err)
+			}
+
+			data, err := ioutil.ReadFile(outside)
+			if (err != nil) || (string(data) != "good") {
+				t.Errorf("File outside of destination was changed: %q, %v", data, err)
+			}
+
+			after, err := os.Stat(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (after.Mode() != before.Mode()) || !after.ModTime().Equal(before.ModTime()) {
+				t.Errorf("Parent of destination was changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Vercmp compares two versions using the same rules as pacman. It
+// returns -1 if ver1 is older than ver2, 0 if they're the same, or 1
+// if ver1 is newer, and nil. The error is always nil; it's there for
+// compatibility with VercmpExternal().
+func Vercmp(ver1, ver2 string) (int, error) {
+	return vercmp(ver1, ver2), nil
+}
+
+// VercmpExternal is like Vercmp(), but it runs pacman's vercmp to do
+// the comparison. It's slow, but useful for checking Vercmp(). If
+// vercmp wasn't found or any other errors occur it returns 0 and an
+// error.
+func VercmpExternal(ver1, ver2 string) (int, error) {
+	if VercmpPath == "" {
+		return 0, fmt.Errorf("Could not find vercmp.")
+	}
+
+	out, err := VercmpOutput(ver1, ver2)
+	if err != nil {
+		return 0, err
+	}
+	out = bytes.TrimSpace(out)
+
+	c, err := strconv.Atoi(string(out))
+	if err != nil {
+		return 0, fmt.Errorf("Bad vercmp output: %s", out)
+	}
+
+	switch {
+	case c < 0:
+		return -1, nil
+	case c > 0:
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// vercmp is a port of libalpm's alpm_pkg_vercmp(). The epochs are
+// compared first, then the versions, and then, if both have one, the
+// releases.
+func vercmp(ver1, ver2 string) int {
+	if ver1 == ver2 {
+		return 0
+	}
+
+	e1, v1, r1 := parseEVR(ver1)
+	e2, v2, r2 := parseEVR(ver2)
+
+	c := rpmvercmp(e1, e2)
+	if c == 0 {
+		c = rpmvercmp(v1, v2)
+		if (c == 0) && (r1 != "") && (r2 != "") {
+			c = rpmvercmp(r1, r2)
+		}
+	}
+
+	return c
+}
+
+// parseEVR splits a version of the form [epoch:]version[-release]
+// into its parts. If there's no epoch, it's "0".
+func parseEVR(evr string) (epoch, ver, rel string) {
+	s := 0
+	for (s < len(evr)) && isDigit(evr[s]) {
+		s++
+	}
+
+	epoch, ver = "0", evr
+	if (s < len(evr)) && (evr[s] == ':') {
+		if s > 0 {
+			epoch = evr[:s]
+		}
+		ver = evr[s+1:]
+	}
+
+	if i := strings.LastIndex(ver, "-"); i >= 0 {
+		ver, rel = ver[:i], ver[i+1:]
+	}
+
+	return
+}
+
+// rpmvercmp is a port of libalpm's rpmvercmp(). Versions are split
+// into alternating runs of digits and letters, ignoring everything
+// else. Numeric runs are compared as numbers, alphabetic runs as
+// strings, and a numeric run is always newer than an alphabetic one.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	// i and j are the positions in a and b. pi and pj mark the end of
+	// the previous segment, so that the separators can be compared.
+	var i, j, pi, pj int
+	for (i < len(a)) && (j < len(b)) {
+		for (i < len(a)) && !isAlnum(a[i]) {
+			i++
+		}
+		for (j < len(b)) && !isAlnum(b[j]) {
+			j++
+		}
+
+		if (i >= len(a)) || (j >= len(b)) {
+			break
+		}
+
+		// If the separator lengths are different, the longer one wins.
+		if (i - pi) != (j - pj) {
+			if (i - pi) < (j - pj) {
+				return -1
+			}
+			return 1
+		}
+
+		pi, pj = i, j
+
+		isnum := isDigit(a[pi])
+		class := isAlpha
+		if isnum {
+			class = isDigit
+		}
+		for (pi < len(a)) && class(a[pi]) {
+			pi++
+		}
+		for (pj < len(b)) && class(b[pj]) {
+			pj++
+		}
+
+		// a's segment can't be empty, since it starts with a character
+		// of the right class. b's can be, if they're different types.
+		if pj == j {
+			if isnum {
+				return 1
+			}
+			return -1
+		}
+
+		seg1, seg2 := a[i:pi], b[j:pj]
+		if isnum {
+			seg1 = strings.TrimLeft(seg1, "0")
+			seg2 = strings.TrimLeft(seg2, "0")
+
+			if len(seg1) > len(seg2) {
+				return 1
+			}
+			if len(seg2) > len(seg1) {
+				return -1
+			}
+		}
+
+		if c := strings.Compare(seg1, seg2); c != 0 {
+			return c
+		}
+
+		i, j = pi, pj
+	}
+
+	if (i >= len(a)) && (j >= len(b)) {
+		return 0
+	}
+
+	// A remaining alphabetic segment never beats an empty one. If a
+	// is empty and b isn't alphabetic, or a is alphabetic, b is newer.
+	// Otherwise a is.
+	if ((i >= len(a)) && !isAlpha(b[j])) || ((i < len(a)) && isAlpha(a[i])) {
+		return -1
+	}
+
+	return 1
+}
+
+func isDigit(c byte) bool {
+	return (c >= '0') && (c <= '9')
+}
+
+func isAlpha(c byte) bool {
+	return ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z'))
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
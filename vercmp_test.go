@@ -0,0 +1,135 @@
+// Copyright 2012 Yissakhar Z. Beck
+//
+// This file is part of pacgo.
+// 
+// pacgo is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+// 
+// pacgo is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+// 
+// You should have received a copy of the GNU General Public License
+// along with pacgo. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+// vercmpTests are the test vectors from pacman's vercmptest.sh. Each
+// one is also checked with the versions swapped.
+var vercmpTests = []struct {
+	ver1, ver2 string
+	result     int
+}{
+	// All similar length, no pkgrel.
+	{"1.5.0", "1.5.0", 0},
+	{"1.5.1", "1.5.0", 1},
+
+	// Mixed length.
+	{"1.5.1", "1.5", 1},
+
+	// With pkgrel, simple.
+	{"1.5.0-1", "1.5.0-1", 0},
+	{"1.5.0-1", "1.5.0-2", -1},
+	{"1.5.0-1", "1.5.1-1", -1},
+	{"1.5.0-2", "1.5.1-1", -1},
+
+	// With pkgrel, mixed lengths.
+	{"1.5-1", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-2", -1},
+
+	// Mixed pkgrel inclusion.
+	{"1.5", "1.5-1", 0},
+	{"1.5-1", "1.5", 0},
+	{"1.1-1", "1.1", 0},
+	{"1.0-1", "1.1", -1},
+	{"1.1-1", "1.0", 1},
+
+	// Alphanumeric versions.
+	{"1.5b-1", "1.5-1", -1},
+	{"1.5b", "1.5", -1},
+	{"1.5b-1", "1.5", -1},
+	{"1.5b", "1.5.1", -1},
+
+	// From the manpage.
+	{"1.0a", "1.0alpha", -1},
+	{"1.0alpha", "1.0b", -1},
+	{"1.0b", "1.0beta", -1},
+	{"1.0beta", "1.0rc", -1},
+	{"1.0rc", "1.0", -1},
+
+	// Alpha-dotted versions.
+	{"1.5.a", "1.5", 1},
+	{"1.5.b", "1.5.a", 1},
+	{"1.5.1", "1.5.b", 1},
+
+	// Alpha dots and dashes.
+	{"1.5.b-1", "1.5.b", 0},
+	{"1.5-1", "1.5.b", -1},
+
+	// Same or similar content, differing separators.
+	{"2.0", "2_0", 0},
+	{"2.0_a", "2_0.a", 0},
+	{"2.0a", "2.0.a", -1},
+	{"2___a", "2_a", 1},
+
+	// Epochs.
+	{"0:1.0", "0:1.0", 0},
+	{"0:1.0", "0:1.1", -1},
+	{"1:1.0", "0:1.0", 1},
+	{"1:1.0", "0:1.1", 1},
+	{"1:1.0", "2:1.1", -1},
+
+	// Epochs and sometimes a pkgrel.
+	{"1:1.0", "0:1.0-1", 1},
+	{"1:1.0-1", "0:1.1-1", 1},
+
+	// An epoch on only one version.
+	{"0:1.0", "1.0", 0},
+	{"0:1.0", "1.1", -1},
+	{"0:1.1", "1.0", 1},
+	{"1:1.0", "1.0", 1},
+	{"1:1.0", "1.1", 1},
+	{"1:1.1", "1.1", 1},
+}
+
+func TestVercmp(t *testing.T) {
+	for _, test := range vercmpTests {
+		r, err := Vercmp(test.ver1, test.ver2)
+		if (err != nil) || (r != test.result) {
+			t.Errorf("Vercmp(%q, %q) = %v, %v; expected %v", test.ver1, test.ver2, r, err, test.result)
+		}
+
+		r, err = Vercmp(test.ver2, test.ver1)
+		if (err != nil) || (r != -test.result) {
+			t.Errorf("Vercmp(%q, %q) = %v, %v; expected %v", test.ver2, test.ver1, r, err, -test.result)
+		}
+	}
+}
+
+func TestVercmpExternal(t *testing.T) {
+	if VercmpPath == "" {
+		t.Skip("vercmp not found")
+	}
+
+	for _, test := range vercmpTests {
+		for _, pair := range [][2]string{{test.ver1, test.ver2}, {test.ver2, test.ver1}} {
+			ext, err := VercmpExternal(pair[0], pair[1])
+			if err != nil {
+				t.Fatalf("VercmpExternal(%q, %q): %v", pair[0], pair[1], err)
+			}
+
+			r, _ := Vercmp(pair[0], pair[1])
+			if r != ext {
+				t.Errorf("Vercmp(%q, %q) = %v, but vercmp says %v", pair[0], pair[1], r, ext)
+			}
+		}
+	}
+}